@@ -0,0 +1,37 @@
+package hub
+
+import (
+	"time"
+)
+
+// Acknowledger settles the delivery of a message, for providers that
+// redeliver messages until they are acknowledged.
+type Acknowledger interface {
+	// Ack reports the message as processed, it is not redelivered.
+	Ack() error
+	// Nak reports the message as not processed, it is redelivered after
+	// the delay.
+	Nak(delay time.Duration) error
+	// Term reports the message as not processable, it is never redelivered.
+	Term() error
+	// InProgress reports the message as still being processed, delaying
+	// its redelivery.
+	InProgress() error
+}
+
+// AckPolicy decides when the bus acknowledges the messages of a
+// subscription on behalf of its handler.
+type AckPolicy int
+
+const (
+	// AckAfterHandler acks messages once the handler returns, unless it
+	// settled them itself.
+	AckAfterHandler AckPolicy = iota
+
+	// AckOnRespond acks messages once the handler responds to them.
+	// Messages never responded to are left to be redelivered.
+	AckOnRespond
+
+	// AckManual leaves acknowledging messages to the handler.
+	AckManual
+)
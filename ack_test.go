@@ -0,0 +1,207 @@
+package hub_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jorgeolivero/hub"
+	"github.com/pborman/uuid"
+)
+
+// AckingConnection attaches an acker recording acknowledgements to the
+// messages of its subscriptions, as providers with acks do.
+type AckingConnection struct {
+	hub.BusConnection
+	Acks chan string
+}
+
+func (c *AckingConnection) Subscribe(subject string) (*hub.Subscription, error) {
+	sub, err := c.BusConnection.Subscribe(subject)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make(chan *hub.Message)
+	go func() {
+		defer close(messages)
+		for msg := range sub.Messages {
+			msg.Acker = &RecordingAcker{acks: c.Acks}
+			messages <- msg
+		}
+	}()
+	return &hub.Subscription{ID: sub.ID, Messages: messages}, nil
+}
+
+type RecordingAcker struct {
+	acks chan string
+}
+
+func (a *RecordingAcker) Ack() error {
+	a.acks <- "ack"
+	return nil
+}
+
+func (a *RecordingAcker) Nak(delay time.Duration) error {
+	a.acks <- "nak"
+	return nil
+}
+
+func (a *RecordingAcker) Term() error {
+	a.acks <- "term"
+	return nil
+}
+
+func (a *RecordingAcker) InProgress() error {
+	a.acks <- "in progress"
+	return nil
+}
+
+func GetAckingBus(t *testing.T) (*hub.Bus, chan string) {
+	acks := make(chan string, 10)
+	bus := hub.NewBus(&AckingConnection{
+		BusConnection: GetBusConnection(t),
+		Acks:          acks,
+	}, hub.JSON)
+	return bus, acks
+}
+
+func ExpectAcks(t *testing.T, acks chan string, expected ...string) {
+	for _, e := range expected {
+		select {
+		case got := <-acks:
+			if got != e {
+				t.Fatalf("Expected: %s Got: %s", e, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for %s", e)
+		}
+	}
+	select {
+	case got := <-acks:
+		t.Fatalf("Unexpected %s", got)
+	case <-time.After(time.Millisecond * 50):
+	}
+}
+
+func TestAckAfterHandler(t *testing.T) {
+	bus, acks := GetAckingBus(t)
+	topic := hub.Topic(uuid.New())
+
+	// subscribe
+	subID, err := bus.Subscribe(topic, func(c *hub.Context) {
+		if err := c.InProgress(); err != nil {
+			t.Errorf("Error reporting progress: %s", err.Error())
+		}
+	})
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+	defer bus.Unsubscribe(subID)
+
+	// publish
+	if err := bus.Publish(topic, Envelope{}); err != nil {
+		t.Fatalf("Error publishing to bus: %s", err.Error())
+	}
+
+	ExpectAcks(t, acks, "in progress", "ack")
+}
+
+func TestAckSettledByHandler(t *testing.T) {
+	bus, acks := GetAckingBus(t)
+	topic := hub.Topic(uuid.New())
+
+	// subscribe, the handler settles the message itself
+	subID, err := bus.Subscribe(topic, func(c *hub.Context) {
+		if err := c.Term(); err != nil {
+			t.Errorf("Error terminating message: %s", err.Error())
+		}
+		if err := c.Ack(); !errors.Is(err, hub.ErrAlreadyAcknowledged) {
+			t.Errorf("Expected already acknowledged error, got: %v", err)
+		}
+	})
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+	defer bus.Unsubscribe(subID)
+
+	// publish
+	if err := bus.Publish(topic, Envelope{}); err != nil {
+		t.Fatalf("Error publishing to bus: %s", err.Error())
+	}
+
+	ExpectAcks(t, acks, "term")
+}
+
+func TestAckOnRespond(t *testing.T) {
+	bus, acks := GetAckingBus(t)
+	topic := hub.Topic(uuid.New())
+
+	// subscribe, acks happen as the response is sent
+	subID, err := bus.Subscribe(topic.Req(), func(c *hub.Context) {
+		c.Respond(Envelope{})
+	}, hub.WithAckPolicy(hub.AckOnRespond))
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+	defer bus.Unsubscribe(subID)
+
+	// request
+	if err := bus.Request(topic, Envelope{}, &Envelope{}); err != nil {
+		t.Fatalf("Error requesting to bus: %s", err.Error())
+	}
+
+	ExpectAcks(t, acks, "ack")
+}
+
+func TestAckManual(t *testing.T) {
+	bus, acks := GetAckingBus(t)
+	topic := hub.Topic(uuid.New())
+
+	// subscribe, the message is not acked for the handler
+	subID, err := bus.Subscribe(topic, func(c *hub.Context) {}, hub.WithAckPolicy(hub.AckManual))
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+	defer bus.Unsubscribe(subID)
+
+	// publish
+	if err := bus.Publish(topic, Envelope{}); err != nil {
+		t.Fatalf("Error publishing to bus: %s", err.Error())
+	}
+
+	ExpectAcks(t, acks)
+}
+
+func TestAckWithoutAcker(t *testing.T) {
+	bc := GetBusConnection(t)
+	bus := hub.NewBus(bc, hub.JSON)
+	topic := hub.Topic(uuid.New())
+	errs := make(chan error, 2)
+
+	// subscribe
+	subID, err := bus.Subscribe(topic, func(c *hub.Context) {
+		errs <- c.InProgress()
+		errs <- c.Nak(time.Second)
+	})
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+	defer bus.Unsubscribe(subID)
+
+	// publish
+	if err := bus.Publish(topic, Envelope{}); err != nil {
+		t.Fatalf("Error publishing to bus: %s", err.Error())
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			if err != nil {
+				t.Fatalf("Expected acknowledgements to be ignored, got: %s", err.Error())
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out")
+		}
+	}
+}
@@ -1,97 +1,239 @@
 package hub
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"time"
+
+	"github.com/pborman/uuid"
 )
 
 type Bus struct {
 	Connection        BusConnection
-	serializer        BusSerializer
+	id                string
+	serializers       *serializerRegistry
+	contentType       string
 	subscriptionsLock sync.RWMutex
 	subscriptions     map[string]*Subscription
+	middlewareLock    sync.RWMutex
+	middleware        []Middleware
+	handlers          sync.WaitGroup
+	isDraining        bool
+	isClosed          bool
+	inbox             *inbox
 	DefaultTimeout    time.Duration
+
+	// SharedInbox receives the responses to every request through a single
+	// subscription of the bus, saving a subscription per request. Responses
+	// then go to the inbox of the bus rather than to topic.ResUnique(), so
+	// listeners of topic.ResWildcard() no longer see them. It is off by
+	// default.
+	SharedInbox bool
 }
 
+// NewBus creates a bus on top of the connection, serializing payloads in
+// the provided format unless a message asks for another content type. It
+// panics when the format is unknown, which is a programmer error with the
+// JSON and BINARY constants. Formats read from configuration should go
+// through NewBusWithFormat.
 func NewBus(bc BusConnection, format SerializationFormat) *Bus {
+	b, err := NewBusWithFormat(bc, format)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// NewBusWithFormat behaves like NewBus, but returns an error when the format
+// is unknown.
+func NewBusWithFormat(bc BusConnection, format SerializationFormat) (*Bus, error) {
+	if _, err := format.GetSerializer(); err != nil {
+		return nil, err
+	}
+
+	id := uuid.New()
 	return &Bus{
 		Connection:     bc,
-		serializer:     format.GetSerializer(),
+		id:             id,
+		serializers:    newSerializerRegistry(),
+		contentType:    format.ContentType(),
 		subscriptions:  make(map[string]*Subscription),
+		inbox:          newInbox(bc, id),
 		DefaultTimeout: time.Second * 5,
-	}
+	}, nil
+}
+
+// ID returns the identifier of the bus, unique to each instance of a
+// service. Responses carry it in their HeaderResponderID header.
+func (b *Bus) ID() string {
+	return b.id
 }
 
 // Request will publish a request to the provided topic and wait for a response.
-// If the request produces an error, an error will be returned.
-func (b *Bus) Request(topic Topic, req, res interface{}) error {
-	// create message
-	data, err := b.serializer.Serialize(req)
-	if err != nil {
+// If the request produces an error, an error will be returned. Options such
+// as WithHeader are applied to the request message.
+func (b *Bus) Request(topic Topic, req, res interface{}, opts ...MessageOption) error {
+	return b.RequestContext(context.Background(), topic, req, res, opts...)
+}
+
+// RequestContext will publish a request to the provided topic and wait for a
+// response until the context is done. When the context has no deadline the
+// bus DefaultTimeout applies. A request that runs out of time returns an
+// error wrapping ErrTimeout, a canceled one an error wrapping ErrCanceled.
+// Errors sent by the handler are returned as a *RemoteError.
+func (b *Bus) RequestContext(ctx context.Context, topic Topic, req, res interface{}, opts ...MessageOption) error {
+	if b.IsClosed() {
+		return ErrClosed
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.DefaultTimeout)
+		defer cancel()
+	}
+	if err := contextError(ctx); err != nil {
 		return err
 	}
-	msg := NewDefaultMessage(func(m *Message) {
+
+	// create message
+	msg := NewDefaultMessage(append([]MessageOption{func(m *Message) {
 		m.Topic = topic.Req().String()
-		m.Reply = topic.ResUnique().String()
 		m.IsResponse = false
-		m.Payload.Data = data
-
-	})
-
-	// subscribe to response
-	sub, err := b.Connection.Subscribe(msg.Reply)
-	if err != nil {
+		m.ContentType = b.contentType
+	}}, opts...)...)
+	if err := b.serialize(msg, req); err != nil {
 		return err
 	}
-	defer func(sub *Subscription) {
-		b.Unsubscribe(sub.ID)
-	}(sub)
+
+	// wait for the response on the inbox, or on a subscription of its own
+	var replies <-chan *Message
+	if b.SharedInbox {
+		reply, ch, err := b.inbox.register()
+		if err != nil {
+			return err
+		}
+		defer b.inbox.release(reply)
+		msg.Reply = reply
+		replies = ch
+	} else {
+		msg.Reply = topic.ResUnique().String()
+		sub, err := b.Connection.Listen(msg.Reply)
+		if err != nil {
+			return err
+		}
+		defer b.Connection.Unsubscribe(sub.ID)
+		replies = sub.Messages
+	}
 
 	// send request
 	if err := b.Connection.Publish(msg); err != nil {
 		return err
 	}
 
-	// get response or timeout
+	// get response, timeout or cancellation
 	select {
-	case msg := <-sub.Messages:
-		if len(msg.Payload.Error) > 0 {
-			return fmt.Errorf(msg.Payload.Error)
+	case msg, ok := <-replies:
+		if !ok {
+			return ErrClosed
+		}
+		if msg.Payload.Error != nil {
+			return msg.Payload.Error
 		}
-		if err := b.serializer.Deserialize(msg.Payload.Data, res); err != nil {
-			return fmt.Errorf("Error deserializing response: %s", err.Error())
+		if err := b.deserialize(msg, res); err != nil {
+			return fmt.Errorf("Error deserializing response: %w", err)
 		}
 		return nil
-	case <-time.After(b.DefaultTimeout):
-		return fmt.Errorf("Request timed out")
+	case <-ctx.Done():
+		return contextError(ctx)
 	}
 }
 
+// RegisterSerializer makes the serializer available for messages of the
+// content type, replacing any serializer registered for it before.
+func (b *Bus) RegisterSerializer(contentType string, s BusSerializer) {
+	b.serializers.register(contentType, s)
+}
+
+// serialize sets the payload data of the message, serialized according to
+// its content type.
+func (b *Bus) serialize(msg *Message, obj interface{}) error {
+	if len(msg.ContentType) == 0 {
+		msg.ContentType = b.contentType
+	}
+	s, err := b.serializers.get(msg.ContentType)
+	if err != nil {
+		return err
+	}
+
+	data, err := s.Serialize(obj)
+	if err != nil {
+		return serializationError(err)
+	}
+	msg.Payload.Data = data
+	return nil
+}
+
+// deserialize decodes the payload data of the message according to its
+// content type. Messages without one are decoded with the bus default.
+func (b *Bus) deserialize(msg *Message, obj interface{}) error {
+	contentType := msg.ContentType
+	if len(contentType) == 0 {
+		contentType = b.contentType
+	}
+	s, err := b.serializers.get(contentType)
+	if err != nil {
+		return err
+	}
+
+	if err := s.Deserialize(msg.Payload.Data, obj); err != nil {
+		return serializationError(err)
+	}
+	return nil
+}
+
+// OnConnectionEvent registers a handler called when the state of the
+// connection changes, for instance while a provider reconnects. Connections
+// that do not implement ConnectionNotifier never call it.
+func (b *Bus) OnConnectionEvent(handler func(ConnectionEvent)) {
+	if notifier, ok := b.Connection.(ConnectionNotifier); ok {
+		notifier.OnConnectionEvent(handler)
+	}
+}
+
+// Use registers middleware wrapping the handlers of every subscription
+// made through the bus.
+func (b *Bus) Use(middleware ...Middleware) {
+	b.middlewareLock.Lock()
+	b.middleware = append(b.middleware, middleware...)
+	b.middlewareLock.Unlock()
+}
+
 // Subscribe will invoke the provided handler with messages directed towards
 // the provided topic. Nodes of the same service will have incoming requests
 // round robbined between them.
-func (b *Bus) Subscribe(topic Topic, handler MessageHandler) (string, error) {
+func (b *Bus) Subscribe(topic Topic, handler MessageHandler, opts ...SubscriptionOption) (string, error) {
+	return b.SubscribeContext(context.Background(), topic, handler, opts...)
+}
+
+// SubscribeContext behaves like Subscribe, but the subscription is removed
+// once the context is done. The context is made available to the handler
+// through Context.Context.
+func (b *Bus) SubscribeContext(ctx context.Context, topic Topic, handler MessageHandler, opts ...SubscriptionOption) (string, error) {
+	if err := contextError(ctx); err != nil {
+		return "", err
+	}
+
+	if err := b.acquire(); err != nil {
+		return "", err
+	}
+
 	sub, err := b.Connection.Subscribe(topic.String())
 	if err != nil {
+		b.handlers.Done()
 		return "", err
 	}
 
-	go func() {
-		// when channel closes, ranges ends
-		for message := range sub.Messages {
-			context := &Context{
-				message: message,
-				bus:     b,
-			}
-			go handler(context)
-		}
-	}()
-
-	// save subscription in map
-	b.subscriptionsLock.Lock()
-	b.subscriptions[sub.ID] = sub
-	b.subscriptionsLock.Unlock()
+	b.serve(ctx, sub, handler, false, newSubscriptionOptions(opts...))
 
 	// return sub.SubscriptionId
 	return sub.ID, nil
@@ -100,49 +242,128 @@ func (b *Bus) Subscribe(topic Topic, handler MessageHandler) (string, error) {
 // Listen will invoke the provided handler with messages directed towards
 // the provided topic. Nodes of the same service will all receive each
 // incoming message.
-func (b *Bus) Listen(topic Topic, handler MessageHandler) (string, error) {
+func (b *Bus) Listen(topic Topic, handler MessageHandler, opts ...SubscriptionOption) (string, error) {
+	return b.ListenContext(context.Background(), topic, handler, opts...)
+}
+
+// ListenContext behaves like Listen, but the subscription is removed once
+// the context is done. The context is made available to the handler through
+// Context.Context.
+func (b *Bus) ListenContext(ctx context.Context, topic Topic, handler MessageHandler, opts ...SubscriptionOption) (string, error) {
+	if err := contextError(ctx); err != nil {
+		return "", err
+	}
+
+	if err := b.acquire(); err != nil {
+		return "", err
+	}
+
 	sub, err := b.Connection.Listen(topic.String())
 	if err != nil {
+		b.handlers.Done()
 		return "", err
 	}
 
+	b.serve(ctx, sub, handler, true, newSubscriptionOptions(opts...))
+
+	// return sub.SubscriptionId
+	return sub.ID, nil
+}
+
+// acquire accounts for a new subscription, unless the bus is draining.
+func (b *Bus) acquire() error {
+	b.subscriptionsLock.Lock()
+	defer b.subscriptionsLock.Unlock()
+
+	if b.isDraining {
+		return ErrClosed
+	}
+	b.handlers.Add(1)
+	return nil
+}
+
+// serve dispatches the messages of the subscription to the handler and
+// registers the subscription with the bus.
+func (b *Bus) serve(ctx context.Context, sub *Subscription, handler MessageHandler, isListener bool, opts *SubscriptionOptions) {
+	handler = b.withRetries(Chain(handler, opts.Middleware...), opts)
+	d := newDispatcher(opts, &b.handlers, func(c *Context) {
+		b.withMiddleware(handler)(c)
+		c.autoAck(AckAfterHandler)
+	})
+
 	go func() {
+		defer b.handlers.Done()
+
 		// when channel closes, ranges ends
 		for message := range sub.Messages {
-			// empty reply field, listeners should not reply to messages
-			message.Reply = ""
-			context := &Context{
-				message: message,
-				bus:     b,
+			if isListener {
+				// empty reply field, listeners should not reply to messages
+				message.Reply = ""
 			}
-			go handler(context)
+			d.dispatch(&Context{
+				ctx:       ctx,
+				message:   message,
+				bus:       b,
+				ackPolicy: opts.AckPolicy,
+			})
 		}
 	}()
 
 	// save subscription in map
 	b.subscriptionsLock.Lock()
 	b.subscriptions[sub.ID] = sub
+	isDraining := b.isDraining
 	b.subscriptionsLock.Unlock()
 
-	// return sub.SubscriptionId
-	return sub.ID, nil
+	// the bus started draining while subscribing
+	if isDraining {
+		b.Unsubscribe(sub.ID)
+	}
+
+	// unsubscribe when the context is done
+	if ctx.Done() != nil {
+		go func() {
+			<-ctx.Done()
+			b.Unsubscribe(sub.ID)
+		}()
+	}
+}
+
+// withMiddleware wraps the handler with the middleware registered on the bus.
+func (b *Bus) withMiddleware(handler MessageHandler) MessageHandler {
+	b.middlewareLock.RLock()
+	defer b.middlewareLock.RUnlock()
+
+	return Chain(handler, b.middleware...)
 }
 
 // Publish the request to the provided topic.
-// This method does not wait for a response, it is fire and forget.
-func (b *Bus) Publish(topic Topic, req interface{}) error {
-	// create message
-	data, err := b.serializer.Serialize(req)
-	if err != nil {
+// This method does not wait for a response, it is fire and forget. Options
+// such as WithHeader are applied to the published message.
+func (b *Bus) Publish(topic Topic, req interface{}, opts ...MessageOption) error {
+	return b.PublishContext(context.Background(), topic, req, opts...)
+}
+
+// PublishContext behaves like Publish, but does not publish once the
+// context is done.
+func (b *Bus) PublishContext(ctx context.Context, topic Topic, req interface{}, opts ...MessageOption) error {
+	if b.IsClosed() {
+		return ErrClosed
+	}
+	if err := contextError(ctx); err != nil {
 		return err
 	}
-	msg := NewDefaultMessage(func(m *Message) {
+
+	// create message
+	msg := NewDefaultMessage(append([]MessageOption{func(m *Message) {
 		m.Topic = topic.String()
 		m.Reply = ""
 		m.IsResponse = false
-		m.Payload.Data = data
-
-	})
+		m.ContentType = b.contentType
+	}}, opts...)...)
+	if err := b.serialize(msg, req); err != nil {
+		return err
+	}
 
 	return b.Connection.Publish(msg)
 }
@@ -159,3 +380,73 @@ func (b *Bus) Unsubscribe(subscriptionIDs ...string) {
 	}
 	b.subscriptionsLock.Unlock()
 }
+
+// Drain gracefully shuts the bus down. Every subscription stops receiving
+// messages, handlers already running or queued are waited for, then the
+// connection flushes pending publishes and closes. Handlers may still
+// publish and make requests while the bus drains. When the context is done
+// before the handlers finish, the connection is closed anyway and an error
+// is returned.
+func (b *Bus) Drain(ctx context.Context) error {
+	b.unsubscribeAll()
+
+	done := make(chan struct{})
+	go func() {
+		b.handlers.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("Draining bus interrupted: %w", ctx.Err())
+	}
+
+	if closeErr := b.close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Close shuts the bus down without waiting for running handlers, their
+// responses will fail to publish.
+func (b *Bus) Close() error {
+	b.unsubscribeAll()
+	return b.close()
+}
+
+// IsClosed reports whether the connection of the bus has been closed.
+func (b *Bus) IsClosed() bool {
+	b.subscriptionsLock.RLock()
+	defer b.subscriptionsLock.RUnlock()
+
+	return b.isClosed
+}
+
+// unsubscribeAll stops the bus from accepting subscriptions and cancels the
+// existing ones.
+func (b *Bus) unsubscribeAll() {
+	b.subscriptionsLock.Lock()
+	b.isDraining = true
+	subscriptionIDs := make([]string, 0, len(b.subscriptions))
+	for subID := range b.subscriptions {
+		subscriptionIDs = append(subscriptionIDs, subID)
+	}
+	b.subscriptionsLock.Unlock()
+
+	b.Unsubscribe(subscriptionIDs...)
+}
+
+func (b *Bus) close() error {
+	b.subscriptionsLock.Lock()
+	if b.isClosed {
+		b.subscriptionsLock.Unlock()
+		return nil
+	}
+	b.isClosed = true
+	b.subscriptionsLock.Unlock()
+
+	b.inbox.close()
+	return b.Connection.Close()
+}
@@ -7,4 +7,6 @@ type BusConnection interface {
 	Unsubscribe(subscriptionIds ...string)
 	Request(message *Message) error
 	ServiceNameIsSet() bool
+	ServiceName() string
+	Close() error
 }
@@ -1,12 +1,14 @@
 package hub_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"testing"
 	"time"
 
 	"github.com/jorgeolivero/hub"
-	"github.com/jorgeolivero/hub/provider/nats"
+	"github.com/jorgeolivero/hub/provider/memory"
 	"github.com/pborman/uuid"
 )
 
@@ -15,11 +17,10 @@ type Envelope struct {
 	Bar string
 }
 
-func GetBusConnection(t *testing.T) hub.BusConnection {
-	cfg := nats.DefaultConfig("HUB_TEST")
-	conn, err := nats.NewConnection(cfg.ConnectionUrl(), cfg)
+func GetBusConnection(t testing.TB) hub.BusConnection {
+	conn, err := memory.NewConnection(memory.DefaultConfig("HUB_TEST"))
 	if err != nil {
-		t.Fatalf("Error creating NATs connection: %s", err.Error())
+		t.Fatalf("Error creating memory connection: %s", err.Error())
 	}
 	return conn
 }
@@ -101,6 +102,308 @@ func TestRequestError(t *testing.T) {
 	}
 }
 
+func TestRequestHeaders(t *testing.T) {
+	bc := GetBusConnection(t)
+	bus := hub.NewBus(bc, hub.JSON)
+
+	topic := hub.Topic(uuid.New())
+	correlationID := uuid.New()
+	responses := make(chan map[string]string, 1)
+
+	// capture the headers of the response
+	listenID, err := bus.Listen(topic.ResWildcard(), func(c *hub.Context) {
+		responses <- c.Headers()
+	})
+	if err != nil {
+		t.Fatalf("Error listening: %s", err.Error())
+	}
+	defer func(subID string) {
+		bus.Unsubscribe(subID)
+	}(listenID)
+
+	// subscribe
+	subID, err := bus.Subscribe(topic.Req(), func(c *hub.Context) {
+		if c.Header("correlation-id") != correlationID {
+			t.Errorf("Request header not received: %v", c.Headers())
+		}
+		c.SetHeader("handled-by", "HUB_TEST")
+		c.Respond(Envelope{})
+	})
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+	defer func(subID string) {
+		bus.Unsubscribe(subID)
+	}(subID)
+
+	// publish
+	if err := bus.Request(topic, Envelope{}, &Envelope{}, hub.WithHeader("correlation-id", correlationID)); err != nil {
+		t.Fatalf("Error requesting to bus: %s", err.Error())
+	}
+
+	select {
+	case headers := <-responses:
+		if headers["correlation-id"] != correlationID || headers["handled-by"] != "HUB_TEST" {
+			t.Fatalf("Headers not propagated to response: %v", headers)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out")
+	}
+}
+
+func TestRequestRemoteError(t *testing.T) {
+	bc := GetBusConnection(t)
+	bus := hub.NewBus(bc, hub.JSON)
+
+	topic := hub.Topic(uuid.New())
+
+	// subscribe
+	subID, err := bus.Subscribe(topic.Req(), func(c *hub.Context) {
+		remoteErr := hub.NewRemoteError("NOT_FOUND", "missing").WithDetail("id", "42")
+		if err := c.RespondError(remoteErr); err != nil {
+			t.Errorf("Responding with error produced error: %s", err.Error())
+		}
+	})
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+	defer func(subID string) {
+		bus.Unsubscribe(subID)
+	}(subID)
+
+	// publish
+	err = bus.Request(topic, Envelope{}, &Envelope{})
+	var remoteErr *hub.RemoteError
+	if !errors.As(err, &remoteErr) {
+		t.Fatalf("Expected remote error, got: %v", err)
+	}
+	if remoteErr.Code != "NOT_FOUND" || remoteErr.Message != "missing" || remoteErr.Details["id"] != "42" {
+		t.Fatalf("Remote error not transported: %#v", remoteErr)
+	}
+	if remoteErr.Service != "HUB_TEST" {
+		t.Fatalf("Remote error origin incorrect: %s", remoteErr.Service)
+	}
+	if !errors.Is(err, hub.NewRemoteError("NOT_FOUND", "")) {
+		t.Fatalf("Remote errors with the same code should match")
+	}
+}
+
+func TestRequestSentinelError(t *testing.T) {
+	bc := GetBusConnection(t)
+	bus := hub.NewBus(bc, hub.JSON)
+
+	topic := hub.Topic(uuid.New())
+
+	// subscribe
+	subID, err := bus.Subscribe(topic.Req(), func(c *hub.Context) {
+		c.RespondError(fmt.Errorf("decoding order: %w", hub.ErrSerialization))
+	})
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+	defer func(subID string) {
+		bus.Unsubscribe(subID)
+	}(subID)
+
+	// publish
+	err = bus.Request(topic, Envelope{}, &Envelope{})
+	if !errors.Is(err, hub.ErrSerialization) {
+		t.Fatalf("Expected serialization error, got: %v", err)
+	}
+	if errors.Is(err, hub.ErrTimeout) {
+		t.Fatalf("Serialization error matched timeout")
+	}
+}
+
+func TestRequestNoResponders(t *testing.T) {
+	bc := GetBusConnection(t)
+	bus := hub.NewBus(bc, hub.JSON)
+
+	err := bus.Request(hub.Topic(uuid.New()), Envelope{}, &Envelope{})
+	if !errors.Is(err, hub.ErrNoResponders) {
+		t.Fatalf("Expected no responders error, got: %v", err)
+	}
+}
+
+func TestRequestContextDeadline(t *testing.T) {
+	bc := GetBusConnection(t)
+	bus := hub.NewBus(bc, hub.JSON)
+
+	topic := hub.Topic(uuid.New())
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	// the handler never responds, the deadline must cut the request short
+	subID, err := bus.Subscribe(topic.Req(), func(c *hub.Context) {})
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+	defer func(subID string) {
+		bus.Unsubscribe(subID)
+	}(subID)
+
+	start := time.Now()
+	err = bus.RequestContext(ctx, topic, Envelope{}, &Envelope{})
+	if !errors.Is(err, hub.ErrTimeout) {
+		t.Fatalf("Expected timeout error, got: %v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected error to wrap the context error, got: %v", err)
+	}
+	if time.Since(start) >= bus.DefaultTimeout {
+		t.Fatalf("Request ignored the context deadline")
+	}
+}
+
+func TestRequestContextCanceled(t *testing.T) {
+	bc := GetBusConnection(t)
+	bus := hub.NewBus(bc, hub.JSON)
+
+	topic := hub.Topic(uuid.New())
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// cancel the request once the handler received it
+	subID, err := bus.Subscribe(topic.Req(), func(c *hub.Context) {
+		cancel()
+	})
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+	defer func(subID string) {
+		bus.Unsubscribe(subID)
+	}(subID)
+
+	err = bus.RequestContext(ctx, topic, Envelope{}, &Envelope{})
+	if !errors.Is(err, hub.ErrCanceled) {
+		t.Fatalf("Expected canceled error, got: %v", err)
+	}
+	if errors.Is(err, hub.ErrTimeout) {
+		t.Fatalf("Cancellation reported as timeout")
+	}
+}
+
+func TestSubscribeContext(t *testing.T) {
+	bc := GetBusConnection(t)
+	bus := hub.NewBus(bc, hub.JSON)
+
+	topic := hub.Topic(uuid.New())
+	ctx, cancel := context.WithCancel(context.Background())
+	received := make(chan struct{}, 1)
+
+	_, err := bus.SubscribeContext(ctx, topic, func(c *hub.Context) {
+		if c.Context() != ctx {
+			t.Errorf("Handler context is not the subscription context")
+		}
+		received <- struct{}{}
+	})
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+
+	if err := bus.Publish(topic, Envelope{}); err != nil {
+		t.Fatalf("Error publishing to bus: %s", err.Error())
+	}
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out")
+	}
+
+	// once cancelled, no more messages are handled
+	cancel()
+	time.Sleep(time.Millisecond * 20)
+	if err := bus.Publish(topic, Envelope{}); err != nil {
+		t.Fatalf("Error publishing to bus: %s", err.Error())
+	}
+	select {
+	case <-received:
+		t.Fatalf("Message handled after context was cancelled")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	if err := bus.PublishContext(ctx, topic, Envelope{}); !errors.Is(err, hub.ErrCanceled) {
+		t.Fatalf("Expected canceled error, got: %v", err)
+	}
+}
+
+func TestRespondToTopic(t *testing.T) {
+	bc := GetBusConnection(t)
+	bus := hub.NewBus(bc, hub.JSON)
+
+	topic := hub.Topic(uuid.New())
+	redirect := hub.Topic(uuid.New())
+	req := Envelope{uuid.New(), uuid.New()}
+	requestIDs := make(chan string, 1)
+	responses := make(chan *hub.Context, 2)
+
+	// listen on the redirect topic
+	listenID, err := bus.Listen(redirect, func(c *hub.Context) {
+		responses <- c
+	})
+	if err != nil {
+		t.Fatalf("Error listening: %s", err.Error())
+	}
+	defer func(subID string) {
+		bus.Unsubscribe(subID)
+	}(listenID)
+
+	// subscribe, responding and then erroring to the redirect topic
+	subID, err := bus.Subscribe(topic, func(c *hub.Context) {
+		requestIDs <- c.MessageID()
+		if err := c.RespondToTopic(redirect, req); err != nil {
+			t.Errorf("Responding to topic produced error: %s", err.Error())
+		}
+		if err := c.RespondErrorToTopic(redirect, hub.ErrSerialization); err != nil {
+			t.Errorf("Responding with error to topic produced error: %s", err.Error())
+		}
+		if err := c.RespondToTopic("", req); err == nil {
+			t.Errorf("Expected responding to an empty topic to fail")
+		}
+	})
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+	defer func(subID string) {
+		bus.Unsubscribe(subID)
+	}(subID)
+
+	// publish, the request itself can not be replied to
+	if err := bus.Publish(topic, req); err != nil {
+		t.Fatalf("Error publishing to bus: %s", err.Error())
+	}
+
+	var requestID string
+	select {
+	case requestID = <-requestIDs:
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out")
+	}
+
+	gotData, gotError := false, false
+	for i := 0; i < 2; i++ {
+		select {
+		case c := <-responses:
+			if c.Header(hub.HeaderInReplyTo) != requestID {
+				t.Fatalf("Response does not reference the request: %v", c.Headers())
+			}
+			if err := c.Error(); err != nil {
+				gotError = errors.Is(err, hub.ErrSerialization)
+				continue
+			}
+			var data Envelope
+			if err := c.Bind(&data); err != nil {
+				t.Fatalf("error binding response: %s", err.Error())
+			}
+			gotData = data == req
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out")
+		}
+	}
+	if !gotData || !gotError {
+		t.Fatalf("Expected a response and an error on the redirect topic")
+	}
+}
+
 func TestPublish(t *testing.T) {
 	bc := GetBusConnection(t)
 	bus := hub.NewBus(bc, hub.JSON)
@@ -256,6 +559,106 @@ func TestListenResponse(t *testing.T) {
 	}
 }
 
+func TestDrain(t *testing.T) {
+	bc := GetBusConnection(t)
+	bus := hub.NewBus(bc, hub.JSON)
+
+	topic := hub.Topic(uuid.New())
+	started := make(chan struct{})
+	finished := make(chan struct{}, 1)
+
+	// subscribe
+	if _, err := bus.Subscribe(topic, func(c *hub.Context) {
+		close(started)
+		time.Sleep(time.Millisecond * 50)
+		finished <- struct{}{}
+	}); err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+
+	// publish
+	if err := bus.Publish(topic, Envelope{}); err != nil {
+		t.Fatalf("Error publishing to bus: %s", err.Error())
+	}
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := bus.Drain(ctx); err != nil {
+		t.Fatalf("Error draining bus: %s", err.Error())
+	}
+
+	// the running handler finished before draining returned
+	select {
+	case <-finished:
+	default:
+		t.Fatalf("Drain returned before the handler finished")
+	}
+
+	if _, err := bus.Subscribe(topic, func(c *hub.Context) {}); !errors.Is(err, hub.ErrClosed) {
+		t.Fatalf("Expected closed error subscribing, got: %v", err)
+	}
+	if err := bus.Publish(topic, Envelope{}); !errors.Is(err, hub.ErrClosed) {
+		t.Fatalf("Expected closed error publishing, got: %v", err)
+	}
+}
+
+func TestDrainInterrupted(t *testing.T) {
+	bc := GetBusConnection(t)
+	bus := hub.NewBus(bc, hub.JSON)
+
+	topic := hub.Topic(uuid.New())
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+
+	// subscribe, the handler outlives the drain deadline
+	if _, err := bus.Subscribe(topic, func(c *hub.Context) {
+		close(started)
+		<-release
+	}); err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+
+	// publish
+	if err := bus.Publish(topic, Envelope{}); err != nil {
+		t.Fatalf("Error publishing to bus: %s", err.Error())
+	}
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	if err := bus.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected drain to be interrupted, got: %v", err)
+	}
+	if !bus.IsClosed() {
+		t.Fatalf("Bus should be closed after an interrupted drain")
+	}
+}
+
+func TestConnectionEvents(t *testing.T) {
+	bc := GetBusConnection(t)
+	bus := hub.NewBus(bc, hub.JSON)
+
+	events := make(chan hub.ConnectionEvent, 1)
+	bus.OnConnectionEvent(func(e hub.ConnectionEvent) {
+		events <- e
+	})
+
+	if err := bus.Close(); err != nil {
+		t.Fatalf("Error closing bus: %s", err.Error())
+	}
+
+	select {
+	case e := <-events:
+		if e.State != hub.StateClosed {
+			t.Fatalf("Expected: %s Got: %s", hub.StateClosed, e.State)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out")
+	}
+}
+
 func TestSubscribe(t *testing.T) {}
 
 func TestUnsubscribe(t *testing.T) {}
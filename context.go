@@ -1,17 +1,56 @@
 package hub
 
 import (
+	"context"
 	"fmt"
+	"sync"
+	"time"
 )
 
 type Context struct {
+	ctx     context.Context
 	message *Message
 	bus     *Bus
+
+	ackPolicy      AckPolicy
+	lock           sync.Mutex
+	isAcknowledged bool
+	hasResponded   bool
+
+	// attempt is set while the handler runs under a retry policy, error
+	// responses are held in it
+	attempt *attempt
+}
+
+// Context returns the context of the subscription that delivered the
+// message. It is done once the subscription is cancelled.
+func (c *Context) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// Topic returns the topic the message was published to.
+func (c *Context) Topic() string {
+	return c.message.Topic
 }
 
-// Binds the payload to the provided data store.
+// MessageID returns the ID of the incoming message.
+func (c *Context) MessageID() string {
+	return c.message.ID
+}
+
+// Binds the payload to the provided data store, using the serializer of the
+// content type of the incoming message.
 func (c *Context) Bind(receiver interface{}) error {
-	return c.bus.serializer.Deserialize(c.message.Payload.Data, receiver)
+	return c.bus.deserialize(c.message, receiver)
+}
+
+// ContentType returns the content type of the incoming message. Responses
+// are serialized with the same content type.
+func (c *Context) ContentType() string {
+	return c.message.ContentType
 }
 
 // GetPayload returns the raw bytes of the context
@@ -19,6 +58,100 @@ func (c *Context) GetPayload() []byte {
 	return c.message.Payload.Data
 }
 
+// Error returns the error carried by the incoming message, when it is an
+// error response.
+func (c *Context) Error() error {
+	if c.message.Payload.Error == nil {
+		return nil
+	}
+	return c.message.Payload.Error
+}
+
+// Header returns the value of a header of the incoming message.
+func (c *Context) Header(key string) string {
+	return c.message.Header(key)
+}
+
+// Headers returns a copy of the headers of the incoming message.
+func (c *Context) Headers() map[string]string {
+	return c.message.copyHeaders()
+}
+
+// SetHeader sets a header on the incoming message. Headers of the incoming
+// message are propagated to responses.
+func (c *Context) SetHeader(key, value string) {
+	c.message.SetHeader(key, value)
+}
+
+// Ack acknowledges the message, the provider does not redeliver it. Unless
+// the handler settles the message itself, the bus acks it according to the
+// AckPolicy of the subscription. Providers without acknowledgements ignore
+// it, as they do the other acknowledgement methods.
+func (c *Context) Ack() error {
+	return c.acknowledge(func(a Acknowledger) error {
+		return a.Ack()
+	})
+}
+
+// Nak asks the provider to redeliver the message after the delay.
+func (c *Context) Nak(delay time.Duration) error {
+	return c.acknowledge(func(a Acknowledger) error {
+		return a.Nak(delay)
+	})
+}
+
+// Term tells the provider never to redeliver the message, for messages the
+// handler will never be able to process.
+func (c *Context) Term() error {
+	return c.acknowledge(func(a Acknowledger) error {
+		return a.Term()
+	})
+}
+
+// InProgress tells the provider the handler is still working on the
+// message, postponing its redelivery. It may be called any number of times
+// until the message is settled.
+func (c *Context) InProgress() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.isAcknowledged {
+		return ErrAlreadyAcknowledged
+	}
+	if c.message.Acker == nil {
+		return nil
+	}
+	return c.message.Acker.InProgress()
+}
+
+// acknowledge settles the message once, returning ErrAlreadyAcknowledged
+// afterwards.
+func (c *Context) acknowledge(f func(a Acknowledger) error) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.isAcknowledged {
+		return ErrAlreadyAcknowledged
+	}
+	c.isAcknowledged = true
+
+	if c.message.Acker == nil {
+		return nil
+	}
+	return f(c.message.Acker)
+}
+
+// autoAck acks the message when the ack policy of the subscription
+// matches, unless the handler settled it already.
+func (c *Context) autoAck(policy AckPolicy) {
+	if c.ackPolicy != policy {
+		return
+	}
+	if err := c.Ack(); err != nil && err != ErrAlreadyAcknowledged {
+		fmt.Printf("Error acknowledging message [%s] on topic [%s]: %s\n", c.MessageID(), c.Topic(), err.Error())
+	}
+}
+
 // Responds using the reply inbox held in the context.
 func (c *Context) Respond(res interface{}) error {
 	// preconditions
@@ -26,50 +159,129 @@ func (c *Context) Respond(res interface{}) error {
 		return fmt.Errorf("Respond OP not allowed: %#v\n", c.message)
 	}
 
-	// create message
-	data, err := c.bus.serializer.Serialize(res)
-	if err != nil {
-		return err
-	}
-	msg := NewDefaultMessage(func(m *Message) {
-		m.Topic = c.message.Reply
-		m.Reply = ""
-		m.IsResponse = true
-		m.Payload.Data = data
-	})
-
-	// publish
-	return c.bus.Connection.Publish(msg)
+	return c.respond(c.message.Reply, res)
 }
 
-// Responds using the provided topic.
+// Responds using the provided topic, regardless of the reply inbox held in
+// the context. The response carries the ID of the incoming message in its
+// HeaderInReplyTo header.
 func (c *Context) RespondToTopic(topic Topic, res interface{}) error {
-	return nil
+	// preconditions
+	if len(topic) == 0 {
+		return fmt.Errorf("RespondToTopic OP not allowed: empty topic")
+	}
+
+	return c.respond(topic.String(), res)
 }
 
 // Responds with an error using the reply inbox held in the context.
+// A *RemoteError is sent as is, any other error is converted into one, its
+// code taken from the sentinel error it wraps.
 func (c *Context) RespondError(err error) error {
 	// pre conditions
 	if !c.IsReplyable() {
 		return fmt.Errorf("RespondError OP not allowed: %#v\n", c.message)
 	}
 
+	return c.respondError(c.message.Reply, err)
+}
+
+// Responds with an error using the provided topic, regardless of the reply
+// inbox held in the context. The error is converted as in RespondError.
+func (c *Context) RespondErrorToTopic(topic Topic, err error) error {
+	// pre conditions
+	if len(topic) == 0 {
+		return fmt.Errorf("RespondErrorToTopic OP not allowed: empty topic")
+	}
+
+	return c.respondError(topic.String(), err)
+}
+
+func (c *Context) respond(topic string, res interface{}) error {
 	// create message
-	msg := NewDefaultMessage(func(m *Message) {
-		m.Topic = c.message.Reply
-		m.Reply = ""
-		m.IsResponse = true
-		m.Payload.Error = err.Error()
-	})
+	msg := c.newResponse(topic)
+	if err := c.bus.serialize(msg, res); err != nil {
+		return err
+	}
 
-	return c.bus.Connection.Publish(msg)
+	// publish
+	if err := c.bus.Connection.Publish(msg); err != nil {
+		return err
+	}
+	c.responded(topic)
+	return nil
 }
 
-// Responds with an error using the provided topic.
-func (c *Context) RespondErrorToTopic(topic Topic, err error) error {
+func (c *Context) respondError(topic string, err error) error {
+	if c.attempt != nil {
+		c.attempt.topic = topic
+		c.attempt.err = err
+		return nil
+	}
+
+	// create message
+	msg := c.newResponse(topic, func(m *Message) {
+		m.Payload.Error = toRemoteError(err, c.bus.Connection.ServiceName())
+	})
+
+	// publish
+	if err := c.bus.Connection.Publish(msg); err != nil {
+		return err
+	}
+	c.responded(topic)
 	return nil
 }
 
+// responded records a response sent to the reply inbox, acking the message
+// for subscriptions acking on respond.
+func (c *Context) responded(topic string) {
+	if topic == c.message.Reply {
+		c.lock.Lock()
+		c.hasResponded = true
+		c.lock.Unlock()
+	}
+	c.autoAck(AckOnRespond)
+}
+
+// HasResponded reports whether a response was sent to the reply inbox of the
+// message. Under a retry policy, an error response held back until the last
+// attempt counts as sent.
+func (c *Context) HasResponded() bool {
+	if c.attempt != nil && c.attempt.err != nil && c.attempt.topic == c.message.Reply {
+		return true
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.hasResponded
+}
+
+// fail reports the error of a handler that cannot respond with it, so that
+// retry policies still apply.
+func (c *Context) fail(err error) {
+	if c.attempt != nil {
+		c.attempt.err = err
+		return
+	}
+	fmt.Printf("Handler for topic [%s] failed: %s\n", c.Topic(), err.Error())
+}
+
+// newResponse creates a response to the incoming message, propagating its
+// headers, referencing its ID and identifying the responder.
+func (c *Context) newResponse(topic string, opts ...MessageOption) *Message {
+	return NewDefaultMessage(append([]MessageOption{func(m *Message) {
+		m.Topic = topic
+		m.Reply = ""
+		m.IsResponse = true
+		m.ContentType = c.message.ContentType
+		m.Headers = c.message.copyHeaders()
+		m.SetHeader(HeaderInReplyTo, c.message.ID)
+		m.SetHeader(HeaderResponderService, c.bus.Connection.ServiceName())
+		m.SetHeader(HeaderResponderID, c.bus.ID())
+	}}, opts...)...)
+}
+
 func (c *Context) IsReplyable() bool {
 	if c.message.IsResponse || len(c.message.Reply) == 0 {
 		return false
@@ -0,0 +1,108 @@
+package hub
+
+import (
+	"fmt"
+	"sync"
+)
+
+// dispatcher hands the messages of a subscription to its handler. Without
+// limits every message gets its own goroutine, otherwise at most maxRunning
+// handlers run while up to queueSize messages wait for their turn, in order.
+type dispatcher struct {
+	handler    MessageHandler
+	handlers   *sync.WaitGroup
+	maxRunning int
+	queueSize  int
+	overflow   OverflowPolicy
+
+	lock    sync.Mutex
+	room    *sync.Cond
+	running int
+	pending []*Context
+}
+
+// newDispatcher creates a dispatcher for the handler, every message is
+// accounted for in handlers until it is handled or dropped.
+func newDispatcher(opts *SubscriptionOptions, handlers *sync.WaitGroup, handler MessageHandler) *dispatcher {
+	d := &dispatcher{
+		handler:    handler,
+		handlers:   handlers,
+		maxRunning: opts.MaxInFlight,
+		queueSize:  opts.QueueSize,
+		overflow:   opts.Overflow,
+	}
+	if opts.Ordered {
+		d.maxRunning = 1
+	}
+	d.room = sync.NewCond(&d.lock)
+	return d
+}
+
+func (d *dispatcher) dispatch(c *Context) {
+	d.handlers.Add(1)
+	if d.maxRunning <= 0 {
+		go func() {
+			defer d.handlers.Done()
+			d.handler(c)
+		}()
+		return
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.overflow == OverflowBlock {
+		for d.running >= d.maxRunning && len(d.pending) >= d.queueSize {
+			d.room.Wait()
+		}
+	}
+
+	switch {
+	case d.running < d.maxRunning:
+		d.running++
+		go d.run(c)
+	case len(d.pending) < d.queueSize:
+		d.pending = append(d.pending, c)
+	case d.overflow == OverflowDropOldest && d.queueSize > 0:
+		dropped := d.pending[0]
+		d.pending = append(d.pending[1:], c)
+		d.handlers.Done()
+		fmt.Printf("Queue for topic [%s] full, dropped message [%s]\n", dropped.Topic(), dropped.MessageID())
+	case d.overflow == OverflowReject:
+		fmt.Printf("Queue for topic [%s] full, rejected message [%s]\n", c.Topic(), c.MessageID())
+		if c.IsReplyable() {
+			go func() {
+				defer d.handlers.Done()
+				c.RespondError(ErrOverloaded)
+			}()
+		} else {
+			d.handlers.Done()
+		}
+	default:
+		// without a queue there is nothing older to drop, so dropping the
+		// oldest message drops the arriving one
+		d.handlers.Done()
+		fmt.Printf("Queue for topic [%s] full, dropped message [%s]\n", c.Topic(), c.MessageID())
+	}
+}
+
+// run handles the message, then keeps handling queued messages until the
+// queue is empty.
+func (d *dispatcher) run(c *Context) {
+	for c != nil {
+		d.handler(c)
+		d.handlers.Done()
+
+		d.lock.Lock()
+		c = nil
+		if len(d.pending) > 0 {
+			c = d.pending[0]
+			d.pending[0] = nil
+			d.pending = d.pending[1:]
+		} else {
+			d.running--
+		}
+		d.room.Signal()
+		d.lock.Unlock()
+	}
+}
@@ -0,0 +1,154 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrClosed is returned by a bus that has been drained or closed.
+	ErrClosed = errors.New("Bus closed")
+
+	// ErrTimeout is returned when a request's deadline passes before a
+	// response arrives.
+	ErrTimeout = errors.New("Request timed out")
+
+	// ErrCanceled is returned when a request's context is canceled before
+	// a response arrives.
+	ErrCanceled = errors.New("Request canceled")
+
+	// ErrNoResponders is returned by providers able to tell that nobody is
+	// subscribed to the topic of a request.
+	ErrNoResponders = errors.New("No responders for request")
+
+	// ErrSerialization wraps failures to serialize or deserialize
+	// message data.
+	ErrSerialization = errors.New("Serialization failed")
+
+	// ErrHandlerPanic is sent to requesters whose handler panicked, see
+	// the Recover middleware.
+	ErrHandlerPanic = errors.New("Handler panicked")
+
+	// ErrOverloaded is sent to requesters whose message was rejected by a
+	// subscription with a full queue.
+	ErrOverloaded = errors.New("Subscription overloaded")
+
+	// ErrNoResponse is sent to requesters whose handler returned without
+	// responding, see Reply and RequireResponse.
+	ErrNoResponse = errors.New("Handler did not respond")
+
+	// ErrAlreadyAcknowledged is returned when acknowledging a message that
+	// has already been acked, naked or terminated.
+	ErrAlreadyAcknowledged = errors.New("Message already acknowledged")
+)
+
+// Error codes carried by a RemoteError. Handlers are free to use their own
+// codes, the ones below map onto the exported sentinel errors.
+const (
+	CodeUnknown       = "UNKNOWN"
+	CodeTimeout       = "TIMEOUT"
+	CodeCanceled      = "CANCELED"
+	CodeNoResponders  = "NO_RESPONDERS"
+	CodeSerialization = "SERIALIZATION"
+	CodeHandlerPanic  = "HANDLER_PANIC"
+	CodeOverloaded    = "OVERLOADED"
+	CodeNoResponse    = "NO_RESPONSE"
+)
+
+var sentinelCodes = map[error]string{
+	ErrTimeout:       CodeTimeout,
+	ErrCanceled:      CodeCanceled,
+	ErrNoResponders:  CodeNoResponders,
+	ErrSerialization: CodeSerialization,
+	ErrHandlerPanic:  CodeHandlerPanic,
+	ErrOverloaded:    CodeOverloaded,
+	ErrNoResponse:    CodeNoResponse,
+}
+
+// RemoteError is an error produced by the handler of a request and carried
+// back to the requester in the response payload.
+type RemoteError struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Details map[string]string `json:"details,omitempty"`
+	Service string            `json:"service,omitempty"`
+}
+
+// NewRemoteError creates a remote error with the provided code and message.
+func NewRemoteError(code, message string) *RemoteError {
+	return &RemoteError{
+		Code:    code,
+		Message: message,
+	}
+}
+
+func (e *RemoteError) Error() string {
+	return e.Message
+}
+
+// Is reports whether the remote error matches the target. Remote errors
+// match the sentinel error of their code, and other remote errors with the
+// same code.
+func (e *RemoteError) Is(target error) bool {
+	if code, ok := sentinelCodes[target]; ok {
+		return e.Code == code
+	}
+	if t, ok := target.(*RemoteError); ok {
+		return e.Code == t.Code
+	}
+	return false
+}
+
+// WithDetail sets a detail on the remote error and returns it, so calls can
+// be chained.
+func (e *RemoteError) WithDetail(key, value string) *RemoteError {
+	if e.Details == nil {
+		e.Details = make(map[string]string)
+	}
+	e.Details[key] = value
+	return e
+}
+
+// toRemoteError converts any error into a remote error that can be sent
+// over the bus, recording the service it originated from.
+func toRemoteError(err error, service string) *RemoteError {
+	var re *RemoteError
+	if errors.As(err, &re) {
+		clone := *re
+		if len(clone.Service) == 0 {
+			clone.Service = service
+		}
+		return &clone
+	}
+
+	code := CodeUnknown
+	for sentinel, c := range sentinelCodes {
+		if errors.Is(err, sentinel) {
+			code = c
+			break
+		}
+	}
+	return &RemoteError{
+		Code:    code,
+		Message: err.Error(),
+		Service: service,
+	}
+}
+
+// serializationError marks the error as a serialization failure.
+func serializationError(err error) error {
+	return fmt.Errorf("%w: %w", ErrSerialization, err)
+}
+
+// contextError translates the error of a finished context into ErrTimeout
+// or ErrCanceled, keeping the original context error in the chain.
+func contextError(ctx context.Context) error {
+	switch err := ctx.Err(); {
+	case errors.Is(err, context.DeadlineExceeded):
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	case err != nil:
+		return fmt.Errorf("%w: %w", ErrCanceled, err)
+	}
+	return nil
+}
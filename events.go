@@ -0,0 +1,40 @@
+package hub
+
+// ConnectionState is the state of the connection underneath a bus.
+type ConnectionState int
+
+const (
+	StateConnected ConnectionState = iota
+	StateDisconnected
+	StateReconnected
+	StateClosed
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case StateConnected:
+		return "connected"
+	case StateDisconnected:
+		return "disconnected"
+	case StateReconnected:
+		return "reconnected"
+	case StateClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// ConnectionEvent reports a change of the connection state, along with the
+// error that caused it if any.
+type ConnectionEvent struct {
+	State ConnectionState
+	Err   error
+}
+
+// ConnectionNotifier is implemented by connections able to report changes
+// of their state. Handlers are called in order of registration and should
+// not block.
+type ConnectionNotifier interface {
+	OnConnectionEvent(handler func(ConnectionEvent))
+}
@@ -0,0 +1,202 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// GatherOptions decide when a scatter-gather request stops collecting
+// responses. It always stops once its context is done.
+type GatherOptions struct {
+	// Count stops gathering once that many responses arrived, zero means
+	// no limit.
+	Count int
+
+	// Quiescence stops gathering once no response arrived for that long,
+	// zero means no limit.
+	Quiescence time.Duration
+
+	// MessageOptions are applied to the request message.
+	MessageOptions []MessageOption
+}
+
+type GatherOption func(o *GatherOptions)
+
+// WithCount stops gathering after n responses.
+func WithCount(n int) GatherOption {
+	return func(o *GatherOptions) {
+		o.Count = n
+	}
+}
+
+// WithQuiescence stops gathering once no response arrived for the duration.
+func WithQuiescence(d time.Duration) GatherOption {
+	return func(o *GatherOptions) {
+		o.Quiescence = d
+	}
+}
+
+// WithMessageOptions applies the options, such as WithHeader, to the
+// request message.
+func WithMessageOptions(opts ...MessageOption) GatherOption {
+	return func(o *GatherOptions) {
+		o.MessageOptions = append(o.MessageOptions, opts...)
+	}
+}
+
+// Responder identifies the bus that sent a response.
+type Responder struct {
+	Service string
+	ID      string
+}
+
+// GatherResponse is one of the responses collected by Gather.
+type GatherResponse struct {
+	Responder Responder
+	// Error is the *RemoteError the responder answered with, if any.
+	Error error
+
+	message *Message
+	bus     *Bus
+}
+
+// Bind deserializes the response into the provided data store.
+func (r *GatherResponse) Bind(res interface{}) error {
+	if r.Error != nil {
+		return r.Error
+	}
+	return r.bus.deserialize(r.message, res)
+}
+
+// ListenRequests will invoke the provided handler with requests directed
+// towards the provided topic. Unlike with Listen, every node of the same
+// service receives each request and may respond to it, see Gather.
+func (b *Bus) ListenRequests(topic Topic, handler MessageHandler, opts ...SubscriptionOption) (string, error) {
+	return b.ListenRequestsContext(context.Background(), topic, handler, opts...)
+}
+
+// ListenRequestsContext behaves like ListenRequests, but the subscription
+// is removed once the context is done.
+func (b *Bus) ListenRequestsContext(ctx context.Context, topic Topic, handler MessageHandler, opts ...SubscriptionOption) (string, error) {
+	if err := contextError(ctx); err != nil {
+		return "", err
+	}
+
+	if err := b.acquire(); err != nil {
+		return "", err
+	}
+
+	sub, err := b.Connection.Listen(topic.Req().String())
+	if err != nil {
+		b.handlers.Done()
+		return "", err
+	}
+
+	b.serve(ctx, sub, handler, false, newSubscriptionOptions(opts...))
+
+	return sub.ID, nil
+}
+
+// Gather publishes a request to the topic and collects the responses of
+// every responder, see ListenRequests, until the options say to stop or the
+// context is done. When the context has no deadline the bus DefaultTimeout
+// applies. The responses gathered are returned along with an error wrapping
+// ErrTimeout when fewer than Count arrived in time, or ErrCanceled when the
+// context was canceled. Reaching the deadline without a Count is not an
+// error. Errors sent by responders are reported in their response.
+func (b *Bus) Gather(ctx context.Context, topic Topic, req interface{}, opts ...GatherOption) ([]*GatherResponse, error) {
+	if b.IsClosed() {
+		return nil, ErrClosed
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.DefaultTimeout)
+		defer cancel()
+	}
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	o := &GatherOptions{}
+	for _, f := range opts {
+		f(o)
+	}
+
+	// create message
+	msg := NewDefaultMessage(append([]MessageOption{func(m *Message) {
+		m.Topic = topic.Req().String()
+		m.Reply = topic.ResUnique().String()
+		m.IsResponse = false
+		m.ContentType = b.contentType
+	}}, o.MessageOptions...)...)
+	if err := b.serialize(msg, req); err != nil {
+		return nil, err
+	}
+
+	// listen to responses, every responder sends its own
+	sub, err := b.Connection.Listen(msg.Reply)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Connection.Unsubscribe(sub.ID)
+
+	// send request
+	if err := b.Connection.Publish(msg); err != nil {
+		return nil, err
+	}
+
+	// no quiescence timer until asked for
+	var quiet <-chan time.Time
+	var timer *time.Timer
+	if o.Quiescence > 0 {
+		timer = time.NewTimer(o.Quiescence)
+		defer timer.Stop()
+		quiet = timer.C
+	}
+
+	responses := []*GatherResponse{}
+	for o.Count <= 0 || len(responses) < o.Count {
+		select {
+		case res, ok := <-sub.Messages:
+			if !ok {
+				return responses, ErrClosed
+			}
+			responses = append(responses, b.newGatherResponse(res))
+			if timer != nil {
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(o.Quiescence)
+			}
+		case <-quiet:
+			return responses, nil
+		case <-ctx.Done():
+			err := contextError(ctx)
+			if errors.Is(err, ErrTimeout) {
+				if o.Count <= 0 {
+					return responses, nil
+				}
+				return responses, fmt.Errorf("%w: %d of %d responses gathered", err, len(responses), o.Count)
+			}
+			return responses, err
+		}
+	}
+	return responses, nil
+}
+
+func (b *Bus) newGatherResponse(msg *Message) *GatherResponse {
+	res := &GatherResponse{
+		Responder: Responder{
+			Service: msg.Header(HeaderResponderService),
+			ID:      msg.Header(HeaderResponderID),
+		},
+		message: msg,
+		bus:     b,
+	}
+	if msg.Payload.Error != nil {
+		res.Error = msg.Payload.Error
+	}
+	return res
+}
@@ -0,0 +1,139 @@
+package hub_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jorgeolivero/hub"
+	"github.com/pborman/uuid"
+)
+
+// ListenRequests makes n buses, on the same connection, respond to the
+// requests of the topic with their ID.
+func ListenRequests(t *testing.T, bc hub.BusConnection, topic hub.Topic, n int) []*hub.Bus {
+	buses := []*hub.Bus{}
+	for i := 0; i < n; i++ {
+		bus := hub.NewBus(bc, hub.JSON)
+		subID, err := bus.ListenRequests(topic, func(c *hub.Context) {
+			c.Respond(Envelope{Foo: bus.ID()})
+		})
+		if err != nil {
+			t.Fatalf("Error listening: %s", err.Error())
+		}
+		t.Cleanup(func() {
+			bus.Unsubscribe(subID)
+		})
+		buses = append(buses, bus)
+	}
+	return buses
+}
+
+func TestGatherCount(t *testing.T) {
+	bc := GetBusConnection(t)
+	bus := hub.NewBus(bc, hub.JSON)
+
+	topic := hub.Topic(uuid.New())
+	responders := ListenRequests(t, bc, topic, 3)
+
+	// gather
+	responses, err := bus.Gather(context.Background(), topic, Envelope{}, hub.WithCount(3))
+	if err != nil {
+		t.Fatalf("Error gathering: %s", err.Error())
+	}
+	if len(responses) != 3 {
+		t.Fatalf("Expected 3 responses, got %d", len(responses))
+	}
+
+	ids := map[string]bool{}
+	for _, r := range responders {
+		ids[r.ID()] = true
+	}
+	for _, res := range responses {
+		data := Envelope{}
+		if err := res.Bind(&data); err != nil {
+			t.Fatalf("Error binding response: %s", err.Error())
+		}
+		if res.Responder.ID != data.Foo || !ids[data.Foo] {
+			t.Fatalf("Unexpected responder: %#v", res.Responder)
+		}
+		if res.Responder.Service != "HUB_TEST" {
+			t.Fatalf("Expected: HUB_TEST Got: %s", res.Responder.Service)
+		}
+		delete(ids, data.Foo)
+	}
+}
+
+func TestGatherQuiescence(t *testing.T) {
+	bc := GetBusConnection(t)
+	bus := hub.NewBus(bc, hub.JSON)
+
+	topic := hub.Topic(uuid.New())
+	ListenRequests(t, bc, topic, 2)
+
+	// one more responder answering with an error
+	subID, err := bus.ListenRequests(topic, func(c *hub.Context) {
+		c.RespondError(errors.New("unavailable"))
+	})
+	if err != nil {
+		t.Fatalf("Error listening: %s", err.Error())
+	}
+	defer bus.Unsubscribe(subID)
+
+	// gather
+	start := time.Now()
+	responses, err := bus.Gather(context.Background(), topic, Envelope{}, hub.WithQuiescence(time.Millisecond*50))
+	if err != nil {
+		t.Fatalf("Error gathering: %s", err.Error())
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("Gathering did not stop on quiescence")
+	}
+	if len(responses) != 3 {
+		t.Fatalf("Expected 3 responses, got %d", len(responses))
+	}
+
+	failed := 0
+	for _, res := range responses {
+		if res.Error != nil {
+			failed++
+			if res.Responder.ID != bus.ID() {
+				t.Fatalf("Error reported for the wrong responder")
+			}
+		}
+	}
+	if failed != 1 {
+		t.Fatalf("Expected 1 error, got %d", failed)
+	}
+}
+
+func TestGatherDeadline(t *testing.T) {
+	bc := GetBusConnection(t)
+	bus := hub.NewBus(bc, hub.JSON)
+
+	topic := hub.Topic(uuid.New())
+	ListenRequests(t, bc, topic, 2)
+
+	// not enough responders for the count
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	responses, err := bus.Gather(ctx, topic, Envelope{}, hub.WithCount(3))
+	if !errors.Is(err, hub.ErrTimeout) {
+		t.Fatalf("Expected timeout error, got: %v", err)
+	}
+	if len(responses) != 2 {
+		t.Fatalf("Expected 2 responses, got %d", len(responses))
+	}
+
+	// without a count the deadline ends gathering
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	responses, err = bus.Gather(ctx, topic, Envelope{})
+	if err != nil {
+		t.Fatalf("Error gathering: %s", err.Error())
+	}
+	if len(responses) != 2 {
+		t.Fatalf("Expected 2 responses, got %d", len(responses))
+	}
+}
@@ -1,3 +1,75 @@
 package hub
 
+import (
+	"fmt"
+)
+
 type MessageHandler func(*Context)
+
+// Middleware wraps a handler, typically to run code before and after it.
+type Middleware func(MessageHandler) MessageHandler
+
+// Chain wraps the handler with the provided middleware. The first
+// middleware is the outermost one.
+func Chain(handler MessageHandler, middleware ...Middleware) MessageHandler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+// ResponseHandler handles a message and returns the response to it, see
+// Reply.
+type ResponseHandler func(*Context) (interface{}, error)
+
+// Reply adapts a ResponseHandler into a MessageHandler. When the message can
+// be replied to, the returned value is sent with Respond, or the error with
+// RespondError, unless the handler already responded itself. A nil value
+// without an error answers with an error wrapping ErrNoResponse. Errors of
+// messages that cannot be replied to are logged, and still trigger the retry
+// policy of the subscription.
+func Reply(handler ResponseHandler) MessageHandler {
+	return func(c *Context) {
+		res, err := handler(c)
+		if !c.IsReplyable() {
+			if err != nil {
+				c.fail(err)
+			}
+			return
+		}
+		if c.HasResponded() {
+			return
+		}
+
+		switch {
+		case err != nil:
+			err = c.RespondError(err)
+		case res == nil:
+			err = c.RespondError(ErrNoResponse)
+		default:
+			err = c.Respond(res)
+		}
+		if err != nil {
+			fmt.Printf("Error responding to message [%s] on topic [%s]: %s\n", c.MessageID(), c.Topic(), err.Error())
+		}
+	}
+}
+
+// Typed adapts a handler of typed requests into a ResponseHandler. The
+// request is bound into a Req before the handler runs, failing to bind
+// answers with the error. The returned Res is sent even when it is a nil
+// pointer.
+func Typed[Req, Res any](handler func(*Context, Req) (Res, error)) ResponseHandler {
+	return func(c *Context) (interface{}, error) {
+		var req Req
+		if err := c.Bind(&req); err != nil {
+			return nil, err
+		}
+
+		res, err := handler(c, req)
+		if err != nil {
+			return nil, err
+		}
+		return res, nil
+	}
+}
@@ -0,0 +1,114 @@
+package hub_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jorgeolivero/hub"
+	"github.com/pborman/uuid"
+)
+
+func TestReply(t *testing.T) {
+	bc := GetBusConnection(t)
+	bus := hub.NewBus(bc, hub.JSON)
+
+	topic := hub.Topic(uuid.New())
+
+	// subscribe
+	subID, err := bus.Subscribe(topic.Req(), hub.Reply(func(c *hub.Context) (interface{}, error) {
+		req := Envelope{}
+		if err := c.Bind(&req); err != nil {
+			return nil, err
+		}
+		return Envelope{Foo: req.Foo, Bar: "bar"}, nil
+	}))
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+	defer bus.Unsubscribe(subID)
+
+	// request
+	res := Envelope{}
+	if err := bus.Request(topic, Envelope{Foo: "foo"}, &res); err != nil {
+		t.Fatalf("Error requesting to bus: %s", err.Error())
+	}
+	if res.Foo != "foo" || res.Bar != "bar" {
+		t.Fatalf("Unexpected response: %#v", res)
+	}
+}
+
+func TestReplyError(t *testing.T) {
+	bc := GetBusConnection(t)
+	bus := hub.NewBus(bc, hub.JSON)
+
+	topic := hub.Topic(uuid.New())
+
+	// subscribe
+	subID, err := bus.Subscribe(topic.Req(), hub.Reply(func(c *hub.Context) (interface{}, error) {
+		return nil, hub.NewRemoteError("NOT_FOUND", "no such thing")
+	}))
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+	defer bus.Unsubscribe(subID)
+
+	// request
+	err = bus.Request(topic, Envelope{}, &Envelope{})
+	var remoteErr *hub.RemoteError
+	if !errors.As(err, &remoteErr) || remoteErr.Code != "NOT_FOUND" {
+		t.Fatalf("Expected NOT_FOUND remote error, got: %v", err)
+	}
+}
+
+func TestReplyNoResponse(t *testing.T) {
+	bc := GetBusConnection(t)
+	bus := hub.NewBus(bc, hub.JSON)
+
+	topic := hub.Topic(uuid.New())
+
+	// subscribe
+	subID, err := bus.Subscribe(topic.Req(), hub.Reply(func(c *hub.Context) (interface{}, error) {
+		return nil, nil
+	}))
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+	defer bus.Unsubscribe(subID)
+
+	// request
+	if err := bus.Request(topic, Envelope{}, &Envelope{}); !errors.Is(err, hub.ErrNoResponse) {
+		t.Fatalf("Expected no response error, got: %v", err)
+	}
+}
+
+func TestTyped(t *testing.T) {
+	bc := GetBusConnection(t)
+	bus := hub.NewBus(bc, hub.JSON)
+
+	topic := hub.Topic(uuid.New())
+
+	// subscribe
+	subID, err := bus.Subscribe(topic.Req(), hub.Reply(hub.Typed(func(c *hub.Context, req Envelope) (*Envelope, error) {
+		if len(req.Foo) == 0 {
+			return nil, errors.New("missing foo")
+		}
+		return &Envelope{Foo: req.Foo + req.Foo}, nil
+	})))
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+	defer bus.Unsubscribe(subID)
+
+	// request
+	res := Envelope{}
+	if err := bus.Request(topic, Envelope{Foo: "foo"}, &res); err != nil {
+		t.Fatalf("Error requesting to bus: %s", err.Error())
+	}
+	if res.Foo != "foofoo" {
+		t.Fatalf("Expected: foofoo Got: %s", res.Foo)
+	}
+
+	if err := bus.Request(topic, Envelope{}, &res); err == nil || err.Error() != "missing foo" {
+		t.Fatalf("Expected missing foo error, got: %v", err)
+	}
+}
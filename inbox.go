@@ -0,0 +1,102 @@
+package hub
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/pborman/uuid"
+)
+
+// inbox receives the responses to the requests of a bus through a single
+// wildcard subscription, routing each of them to its request by the last
+// token of its topic.
+type inbox struct {
+	connection BusConnection
+	prefix     string
+
+	lock    sync.Mutex
+	sub     *Subscription
+	pending map[string]chan *Message
+}
+
+func newInbox(bc BusConnection, id string) *inbox {
+	return &inbox{
+		connection: bc,
+		prefix:     "_INBOX." + id,
+		pending:    make(map[string]chan *Message),
+	}
+}
+
+// register returns the reply topic of a new request and the channel its
+// response is delivered on. The channel is closed if the inbox closes first.
+func (in *inbox) register() (string, chan *Message, error) {
+	in.lock.Lock()
+	defer in.lock.Unlock()
+
+	// subscribe on the first request
+	if in.sub == nil {
+		sub, err := in.connection.Listen(in.prefix + ".*")
+		if err != nil {
+			return "", nil, err
+		}
+		in.sub = sub
+		go in.run(sub)
+	}
+
+	token := uuid.New()
+	replies := make(chan *Message, 1)
+	in.pending[token] = replies
+	return in.prefix + "." + token, replies, nil
+}
+
+// release forgets the request, its late responses are dropped.
+func (in *inbox) release(reply string) {
+	in.lock.Lock()
+	defer in.lock.Unlock()
+
+	delete(in.pending, in.token(reply))
+}
+
+func (in *inbox) token(reply string) string {
+	return strings.TrimPrefix(reply, in.prefix+".")
+}
+
+// run routes responses until the subscription closes, then closes the
+// channels of the pending requests.
+func (in *inbox) run(sub *Subscription) {
+	for msg := range sub.Messages {
+		in.lock.Lock()
+		replies, ok := in.pending[in.token(msg.Topic)]
+		if ok {
+			// only the first response counts
+			delete(in.pending, in.token(msg.Topic))
+		}
+		in.lock.Unlock()
+
+		// late and duplicate responses are expected, they are dropped
+		if !ok {
+			continue
+		}
+		replies <- msg
+	}
+
+	in.lock.Lock()
+	defer in.lock.Unlock()
+
+	in.sub = nil
+	for token, replies := range in.pending {
+		close(replies)
+		delete(in.pending, token)
+	}
+}
+
+// close unsubscribes the inbox.
+func (in *inbox) close() {
+	in.lock.Lock()
+	sub := in.sub
+	in.lock.Unlock()
+
+	if sub != nil {
+		in.connection.Unsubscribe(sub.ID)
+	}
+}
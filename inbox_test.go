@@ -0,0 +1,88 @@
+package hub_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/jorgeolivero/hub"
+	"github.com/pborman/uuid"
+)
+
+// TestSharedInboxConcurrentRequests ensures that concurrent requests each
+// receive their own response through the shared inbox.
+func TestSharedInboxConcurrentRequests(t *testing.T) {
+	bc := GetBusConnection(t)
+	bus := hub.NewBus(bc, hub.JSON)
+	bus.SharedInbox = true
+
+	topic := hub.Topic(uuid.New())
+
+	// subscribe, echo the request
+	subID, err := bus.Subscribe(topic.Req(), func(c *hub.Context) {
+		var data Envelope
+		c.Bind(&data)
+		c.Respond(data)
+	})
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+	defer bus.Unsubscribe(subID)
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			req := Envelope{Foo: fmt.Sprint(i)}
+			res := Envelope{}
+			if err := bus.Request(topic, req, &res); err != nil {
+				t.Errorf("Error requesting to bus: %s", err.Error())
+				return
+			}
+			if res.Foo != req.Foo {
+				t.Errorf("Expected: %s Got: %s", req.Foo, res.Foo)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// a single subscription serves every request, besides the handler's
+	if n := bc.(interface{ GetNumActiveSubscriptions() int }).GetNumActiveSubscriptions(); n != 2 {
+		t.Fatalf("Expected 2 active subscriptions, got %d", n)
+	}
+}
+
+func BenchmarkRequestSharedInbox(b *testing.B) {
+	benchmarkRequest(b, true)
+}
+
+func BenchmarkRequestPerRequestInbox(b *testing.B) {
+	benchmarkRequest(b, false)
+}
+
+func benchmarkRequest(b *testing.B, sharedInbox bool) {
+	bc := GetBusConnection(b)
+	bus := hub.NewBus(bc, hub.JSON)
+	bus.SharedInbox = sharedInbox
+
+	topic := hub.Topic(uuid.New())
+	subID, err := bus.Subscribe(topic.Req(), func(c *hub.Context) {
+		c.Respond(Envelope{})
+	})
+	if err != nil {
+		b.Fatalf("Error subscribing: %s", err.Error())
+	}
+	defer bus.Unsubscribe(subID)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := bus.Request(topic, Envelope{}, &Envelope{}); err != nil {
+				b.Errorf("Error requesting to bus: %s", err.Error())
+				return
+			}
+		}
+	})
+}
@@ -4,20 +4,63 @@ import (
 	"github.com/pborman/uuid"
 )
 
+const (
+	// HeaderInReplyTo holds, on responses, the ID of the message responded
+	// to.
+	HeaderInReplyTo = "In-Reply-To"
+	// HeaderResponderService and HeaderResponderID identify, on responses,
+	// the service and bus that responded.
+	HeaderResponderService = "Responder-Service"
+	HeaderResponderID      = "Responder-ID"
+)
+
 type Message struct {
 	ID         string
 	Topic      string
 	Reply      string
 	IsResponse bool
-	Payload    Payload
+	Headers    map[string]string
+	// ContentType names the serializer of the payload data.
+	ContentType string
+	Payload     Payload
+	// Acker is set by providers that expect messages to be acknowledged,
+	// it is not sent along with the message.
+	Acker Acknowledger
 }
 
 type Payload struct {
-	Error string `json:"error"`
-	Data  []byte `json:"data"`
+	Error *RemoteError `json:"error,omitempty"`
+	Data  []byte       `json:"data"`
+}
+
+// MessageOption modifies a message before it is sent.
+type MessageOption func(m *Message)
+
+// WithHeader sets a header on the message.
+func WithHeader(key, value string) MessageOption {
+	return func(m *Message) {
+		m.SetHeader(key, value)
+	}
 }
 
-func NewDefaultMessage(opts ...func(m *Message)) *Message {
+// WithContentType serializes the message payload with the serializer
+// registered for the content type, instead of the bus default.
+func WithContentType(contentType string) MessageOption {
+	return func(m *Message) {
+		m.ContentType = contentType
+	}
+}
+
+// WithHeaders sets each of the provided headers on the message.
+func WithHeaders(headers map[string]string) MessageOption {
+	return func(m *Message) {
+		for key, value := range headers {
+			m.SetHeader(key, value)
+		}
+	}
+}
+
+func NewDefaultMessage(opts ...MessageOption) *Message {
 	msg := &Message{
 		ID: uuid.New(),
 	}
@@ -28,3 +71,29 @@ func NewDefaultMessage(opts ...func(m *Message)) *Message {
 
 	return msg
 }
+
+// Header returns the value of the header, or an empty string when it is
+// not set.
+func (m *Message) Header(key string) string {
+	return m.Headers[key]
+}
+
+// SetHeader sets the value of the header.
+func (m *Message) SetHeader(key, value string) {
+	if m.Headers == nil {
+		m.Headers = make(map[string]string)
+	}
+	m.Headers[key] = value
+}
+
+// copyHeaders returns a copy of the message headers.
+func (m *Message) copyHeaders() map[string]string {
+	if len(m.Headers) == 0 {
+		return nil
+	}
+	headers := make(map[string]string, len(m.Headers))
+	for key, value := range m.Headers {
+		headers[key] = value
+	}
+	return headers
+}
@@ -0,0 +1,72 @@
+package hub
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Recover recovers from panics in handlers. When the message can be replied
+// to, the requester receives an error wrapping ErrHandlerPanic instead of
+// waiting for a timeout.
+func Recover() Middleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(c *Context) {
+			defer func() {
+				if r := recover(); r != nil {
+					fmt.Printf("Handler for topic [%s] panicked: %v\n", c.Topic(), r)
+					if c.IsReplyable() {
+						c.RespondError(fmt.Errorf("%w: %v", ErrHandlerPanic, r))
+					}
+				}
+			}()
+			next(c)
+		}
+	}
+}
+
+// RequireResponse answers requests whose handler returned without
+// responding with an error wrapping ErrNoResponse, instead of leaving the
+// requester waiting for a timeout. Handlers must not respond after they
+// return.
+func RequireResponse() Middleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(c *Context) {
+			next(c)
+			if c.IsReplyable() && !c.HasResponded() {
+				c.RespondError(ErrNoResponse)
+			}
+		}
+	}
+}
+
+// Logger logs every handled message along with how long its handler took.
+// A nil logger writes to the standard logger.
+func Logger(logger *log.Logger) Middleware {
+	printf := log.Printf
+	if logger != nil {
+		printf = logger.Printf
+	}
+
+	return func(next MessageHandler) MessageHandler {
+		return func(c *Context) {
+			start := time.Now()
+			next(c)
+			printf("Handled message [%s] on topic [%s] in %s", c.MessageID(), c.Topic(), time.Since(start))
+		}
+	}
+}
+
+// Timing reports the duration of every handler invocation to the provided
+// function, to feed metrics.
+func Timing(observe func(topic string, duration time.Duration)) Middleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(c *Context) {
+			start := time.Now()
+			defer func() {
+				observe(c.Topic(), time.Since(start))
+			}()
+			next(c)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package hub_test
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jorgeolivero/hub"
+	"github.com/pborman/uuid"
+)
+
+func TestMiddlewareOrder(t *testing.T) {
+	bc := GetBusConnection(t)
+	bus := hub.NewBus(bc, hub.JSON)
+
+	topic := hub.Topic(uuid.New())
+	lock := &sync.Mutex{}
+	var calls []string
+	record := func(name string) hub.Middleware {
+		return func(next hub.MessageHandler) hub.MessageHandler {
+			return func(c *hub.Context) {
+				lock.Lock()
+				calls = append(calls, name)
+				lock.Unlock()
+				next(c)
+			}
+		}
+	}
+
+	bus.Use(record("bus1"), record("bus2"))
+
+	// subscribe
+	subID, err := bus.Subscribe(topic.Req(), func(c *hub.Context) {
+		c.Respond(Envelope{})
+	}, hub.WithMiddleware(record("sub")))
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+	defer func(subID string) {
+		bus.Unsubscribe(subID)
+	}(subID)
+
+	// publish
+	if err := bus.Request(topic, Envelope{}, &Envelope{}); err != nil {
+		t.Fatalf("Error requesting to bus: %s", err.Error())
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if strings.Join(calls, ",") != "bus1,bus2,sub" {
+		t.Fatalf("Middleware called in the wrong order: %v", calls)
+	}
+}
+
+func TestRequireResponseMiddleware(t *testing.T) {
+	bc := GetBusConnection(t)
+	bus := hub.NewBus(bc, hub.JSON)
+
+	topic := hub.Topic(uuid.New())
+
+	// subscribe, the handler forgets to respond
+	subID, err := bus.Subscribe(topic.Req(), func(c *hub.Context) {}, hub.WithMiddleware(hub.RequireResponse()))
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+	defer func(subID string) {
+		bus.Unsubscribe(subID)
+	}(subID)
+
+	// publish
+	if err := bus.Request(topic, Envelope{}, &Envelope{}); !errors.Is(err, hub.ErrNoResponse) {
+		t.Fatalf("Expected no response error, got: %v", err)
+	}
+}
+
+func TestRequireResponseWithRetry(t *testing.T) {
+	bc := GetBusConnection(t)
+	bus := hub.NewBus(bc, hub.JSON)
+
+	topic := hub.Topic(uuid.New())
+
+	// subscribe, the error response is held back between attempts
+	subID, err := bus.Subscribe(topic.Req(), func(c *hub.Context) {
+		c.RespondError(hub.NewRemoteError("NOT_FOUND", "missing"))
+	}, hub.WithRetry(hub.ExponentialBackoff(2, time.Millisecond, time.Millisecond)), hub.WithMiddleware(hub.RequireResponse()))
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+	defer bus.Unsubscribe(subID)
+
+	// publish, the error of the handler is returned
+	err = bus.Request(topic, Envelope{}, &Envelope{})
+	var re *hub.RemoteError
+	if !errors.As(err, &re) || re.Code != "NOT_FOUND" {
+		t.Fatalf("Expected the handler error, got: %v", err)
+	}
+}
+
+func TestRecoverMiddleware(t *testing.T) {
+	bc := GetBusConnection(t)
+	bus := hub.NewBus(bc, hub.JSON)
+	bus.Use(hub.Recover())
+
+	topic := hub.Topic(uuid.New())
+
+	// subscribe
+	subID, err := bus.Subscribe(topic.Req(), func(c *hub.Context) {
+		panic("boom")
+	})
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+	defer func(subID string) {
+		bus.Unsubscribe(subID)
+	}(subID)
+
+	// publish
+	err = bus.Request(topic, Envelope{}, &Envelope{})
+	if !errors.Is(err, hub.ErrHandlerPanic) {
+		t.Fatalf("Expected handler panic error, got: %v", err)
+	}
+}
+
+func TestTimingMiddleware(t *testing.T) {
+	bc := GetBusConnection(t)
+	bus := hub.NewBus(bc, hub.JSON)
+
+	topic := hub.Topic(uuid.New())
+	timings := make(chan time.Duration, 1)
+	timing := hub.Timing(func(topic string, d time.Duration) {
+		timings <- d
+	})
+
+	// subscribe
+	subID, err := bus.Subscribe(topic, func(c *hub.Context) {
+		time.Sleep(time.Millisecond * 10)
+	}, hub.WithMiddleware(timing))
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+	defer func(subID string) {
+		bus.Unsubscribe(subID)
+	}(subID)
+
+	// publish
+	if err := bus.Publish(topic, Envelope{}); err != nil {
+		t.Fatalf("Error publishing to bus: %s", err.Error())
+	}
+
+	select {
+	case d := <-timings:
+		if d < time.Millisecond*10 {
+			t.Fatalf("Timing too short: %s", d)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out")
+	}
+}
@@ -0,0 +1,105 @@
+package memory
+
+import (
+	"sync"
+
+	"github.com/jorgeolivero/hub"
+)
+
+// Broker routes messages between in-memory connections. Connections that
+// share a broker can reach each other, much like NATs clients connected to
+// the same server.
+type Broker struct {
+	lock          sync.RWMutex
+	subscriptions []*subscription
+	groupCursors  map[string]int
+}
+
+// DefaultBroker is used by connections whose config does not provide one.
+var DefaultBroker = NewBroker()
+
+func NewBroker() *Broker {
+	return &Broker{
+		groupCursors: make(map[string]int),
+	}
+}
+
+func (b *Broker) add(sub *subscription) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.subscriptions = append(b.subscriptions, sub)
+}
+
+func (b *Broker) remove(sub *subscription) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	key := sub.groupKey()
+	remaining := false
+	for i := len(b.subscriptions) - 1; i >= 0; i-- {
+		s := b.subscriptions[i]
+		if s == sub {
+			b.subscriptions = append(b.subscriptions[:i], b.subscriptions[i+1:]...)
+		} else if len(key) > 0 && s.groupKey() == key {
+			remaining = true
+		}
+	}
+
+	// forget the round robin position once a group is gone
+	if len(key) > 0 && !remaining {
+		delete(b.groupCursors, key)
+	}
+}
+
+// publish delivers the message to every listener on a matching subject and
+// to exactly one member of each matching queue group. Members of a group
+// take turns receiving messages. It reports whether anyone received it.
+func (b *Broker) publish(msg *hub.Message) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	delivered := false
+	groups := make(map[string][]*subscription)
+	var order []string
+	for _, sub := range b.subscriptions {
+		if !hub.Topic(sub.subject).Matches(msg.Topic) {
+			continue
+		}
+		if len(sub.group) == 0 {
+			sub.push(cloneMessage(msg))
+			delivered = true
+			continue
+		}
+		key := sub.groupKey()
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], sub)
+	}
+
+	for _, key := range order {
+		members := groups[key]
+		cursor := b.groupCursors[key] % len(members)
+		b.groupCursors[key] = cursor + 1
+		members[cursor].push(cloneMessage(msg))
+		delivered = true
+	}
+	return delivered
+}
+
+// cloneMessage gives every subscriber its own copy of the message, the same
+// way a network transport would.
+func cloneMessage(msg *hub.Message) *hub.Message {
+	clone := *msg
+	if msg.Headers != nil {
+		clone.Headers = make(map[string]string, len(msg.Headers))
+		for key, value := range msg.Headers {
+			clone.Headers[key] = value
+		}
+	}
+	if msg.Payload.Data != nil {
+		clone.Payload.Data = append([]byte(nil), msg.Payload.Data...)
+	}
+	return &clone
+}
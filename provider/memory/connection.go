@@ -0,0 +1,158 @@
+package memory
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/jorgeolivero/hub"
+	"github.com/pborman/uuid"
+)
+
+var ErrConnectionClosed = errors.New("Connection closed")
+
+type Config struct {
+	Service string
+	Broker  *Broker
+}
+
+func DefaultConfig(service string) *Config {
+	return &Config{
+		Service: service,
+		Broker:  DefaultBroker,
+	}
+}
+
+// Connection is an in-process hub.BusConnection. Subscriptions made through
+// connections with the same service name form a queue group and have
+// messages round robbined between them, listeners all receive each message.
+type Connection struct {
+	Config *Config
+
+	subscriptions     map[string]*subscription
+	subscriptionsLock *sync.Mutex
+	isClosed          bool
+	handlers          []func(hub.ConnectionEvent)
+}
+
+func NewConnection(config *Config) (*Connection, error) {
+	if config.Broker == nil {
+		config.Broker = DefaultBroker
+	}
+
+	return &Connection{
+		Config:            config,
+		subscriptions:     make(map[string]*subscription),
+		subscriptionsLock: &sync.Mutex{},
+	}, nil
+}
+
+func (mc *Connection) IsOpen() bool {
+	mc.subscriptionsLock.Lock()
+	defer mc.subscriptionsLock.Unlock()
+
+	return !mc.isClosed
+}
+
+func (mc *Connection) Listen(subject string) (*hub.Subscription, error) {
+	return mc.subscribe(subject, "")
+}
+
+func (mc *Connection) Subscribe(subject string) (*hub.Subscription, error) {
+	return mc.subscribe(subject, mc.Config.Service)
+}
+
+func (mc *Connection) subscribe(subject, group string) (*hub.Subscription, error) {
+	mc.subscriptionsLock.Lock()
+	defer mc.subscriptionsLock.Unlock()
+
+	if mc.isClosed {
+		return nil, ErrConnectionClosed
+	}
+
+	sub := newSubscription(subject, group)
+	mc.Config.Broker.add(sub)
+
+	subID := uuid.New()
+	mc.subscriptions[subID] = sub
+	return &hub.Subscription{
+		ID:       subID,
+		Messages: sub.messages,
+	}, nil
+}
+
+func (mc *Connection) Unsubscribe(subscriptionIds ...string) {
+	mc.subscriptionsLock.Lock()
+	defer mc.subscriptionsLock.Unlock()
+
+	for _, sid := range subscriptionIds {
+		if sub, ok := mc.subscriptions[sid]; ok {
+			mc.Config.Broker.remove(sub)
+			sub.close()
+			delete(mc.subscriptions, sid)
+		}
+	}
+}
+
+// OnConnectionEvent registers a handler called when the connection closes.
+// The in-process connection never disconnects.
+func (mc *Connection) OnConnectionEvent(handler func(hub.ConnectionEvent)) {
+	mc.subscriptionsLock.Lock()
+	defer mc.subscriptionsLock.Unlock()
+
+	mc.handlers = append(mc.handlers, handler)
+}
+
+// Publish delivers the message to the matching subscriptions. Requests, that
+// is messages expecting a reply, fail with hub.ErrNoResponders when nobody
+// is subscribed to their topic.
+func (mc *Connection) Publish(msg *hub.Message) error {
+	if !mc.IsOpen() {
+		return ErrConnectionClosed
+	}
+	if !mc.Config.Broker.publish(msg) && len(msg.Reply) > 0 {
+		return hub.ErrNoResponders
+	}
+	return nil
+}
+
+// Close removes the subscriptions of the connection. Publishes are delivered
+// synchronously, so there is nothing left to flush.
+func (mc *Connection) Close() error {
+	mc.subscriptionsLock.Lock()
+	if mc.isClosed {
+		mc.subscriptionsLock.Unlock()
+		return nil
+	}
+	for sid, sub := range mc.subscriptions {
+		mc.Config.Broker.remove(sub)
+		sub.close()
+		delete(mc.subscriptions, sid)
+	}
+	mc.isClosed = true
+	handlers := mc.handlers
+	mc.subscriptionsLock.Unlock()
+
+	for _, handler := range handlers {
+		handler(hub.ConnectionEvent{State: hub.StateClosed})
+	}
+	return nil
+}
+
+func (mc *Connection) Request(msg *hub.Message) error {
+	return mc.Publish(msg)
+}
+
+func (mc *Connection) GetNumActiveSubscriptions() int {
+	mc.subscriptionsLock.Lock()
+	defer mc.subscriptionsLock.Unlock()
+
+	return len(mc.subscriptions)
+}
+
+func (mc *Connection) ServiceNameIsSet() bool {
+	return len(mc.Config.Service) > 0
+}
+
+func (mc *Connection) ServiceName() string {
+	return mc.Config.Service
+}
@@ -0,0 +1,305 @@
+package memory_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jorgeolivero/hub"
+	"github.com/jorgeolivero/hub/provider/memory"
+	"github.com/pborman/uuid"
+)
+
+func GetMemoryTestConnection(t *testing.T, broker *memory.Broker) *memory.Connection {
+	cfg := memory.DefaultConfig("CONNECTION_TEST")
+	cfg.Broker = broker
+	conn, err := memory.NewConnection(cfg)
+	if err != nil {
+		t.Fatalf("Error creating memory connection: %s", err.Error())
+	}
+	return conn
+}
+
+func GenerateMsg(opts ...func(m *hub.Message)) *hub.Message {
+	topic := uuid.New()
+	msg := &hub.Message{
+		ID:         uuid.New(),
+		Topic:      topic,
+		Reply:      topic + ".RES",
+		IsResponse: false,
+		Payload:    hub.Payload{},
+	}
+
+	for _, o := range opts {
+		o(msg)
+	}
+
+	return msg
+}
+
+// TestSubscribeTopic ensures that an open subscriptions to a topic
+// is able to recieve messages.
+func TestSubscribeTopic(t *testing.T) {
+	msg := GenerateMsg()
+
+	// subscribe
+	conn := GetMemoryTestConnection(t, memory.NewBroker())
+	sub, err := conn.Subscribe(msg.Topic)
+	if err != nil {
+		t.Fatalf("Error subscribing to subject")
+	}
+
+	// publish
+	if err := conn.Publish(msg); err != nil {
+		t.Fatalf("Error publishing message")
+	}
+
+	// wait
+	select {
+	case rec := <-sub.Messages:
+		if rec.ID != msg.ID {
+			t.Fatalf("Received incorrect message")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Subscription timed out")
+	}
+}
+
+// TestMultiSubscribeTopic ensures that subscriptions from the same
+// service, even across connections, have messages round robbined
+// between them.
+func TestMultiSubscribeTopic(t *testing.T) {
+	broker := memory.NewBroker()
+	topic := uuid.New()
+
+	// subscribe
+	sub1, err := GetMemoryTestConnection(t, broker).Subscribe(topic)
+	if err != nil {
+		t.Fatalf("Error subscribing to subject")
+	}
+	sub2, err := GetMemoryTestConnection(t, broker).Subscribe(topic)
+	if err != nil {
+		t.Fatalf("Error subscribing to subject")
+	}
+
+	// publish
+	conn := GetMemoryTestConnection(t, broker)
+	n := 4
+	for i := 0; i < n; i++ {
+		msg := GenerateMsg(func(m *hub.Message) {
+			m.Topic = topic
+		})
+		if err := conn.Publish(msg); err != nil {
+			t.Fatalf("Error publishing message")
+		}
+	}
+
+	// ensure the messages were split evenly
+	counts := make([]int, 2)
+	for i := 0; i < n; i++ {
+		select {
+		case <-sub1.Messages:
+			counts[0]++
+		case <-sub2.Messages:
+			counts[1]++
+		case <-time.After(time.Second):
+			t.Fatalf("Subscription timed out")
+		}
+	}
+	select {
+	case <-sub1.Messages:
+		t.Fatalf("Message delivered more than once")
+	case <-sub2.Messages:
+		t.Fatalf("Message delivered more than once")
+	case <-time.After(time.Millisecond * 50):
+	}
+	if counts[0] != n/2 || counts[1] != n/2 {
+		t.Fatalf("Messages not round robbined: %v", counts)
+	}
+}
+
+// TestSubscribeSeparateServices ensures that each service gets its own
+// copy of a message.
+func TestSubscribeSeparateServices(t *testing.T) {
+	broker := memory.NewBroker()
+	msg := GenerateMsg()
+
+	// subscribe
+	sub1, err := GetMemoryTestConnection(t, broker).Subscribe(msg.Topic)
+	if err != nil {
+		t.Fatalf("Error subscribing to subject")
+	}
+	other, _ := memory.NewConnection(&memory.Config{Service: "OTHER", Broker: broker})
+	sub2, err := other.Subscribe(msg.Topic)
+	if err != nil {
+		t.Fatalf("Error subscribing to subject")
+	}
+
+	// publish
+	if err := other.Publish(msg); err != nil {
+		t.Fatalf("Error publishing message")
+	}
+
+	for _, sub := range []*hub.Subscription{sub1, sub2} {
+		select {
+		case rec := <-sub.Messages:
+			if rec.ID != msg.ID {
+				t.Fatalf("Received incorrect message")
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Subscription timed out")
+		}
+	}
+}
+
+// TestMultiListenTopic ensures that when multiple listners are made
+// to the same topic, each listener receives all messages sent to that topic.
+func TestMultiListenTopic(t *testing.T) {
+	msg := GenerateMsg()
+
+	// listen
+	conn := GetMemoryTestConnection(t, memory.NewBroker())
+	sub1, err := conn.Listen(msg.Topic)
+	if err != nil {
+		t.Fatalf("Error subscribing to subject")
+	}
+	sub2, err := conn.Listen(msg.Topic)
+	if err != nil {
+		t.Fatalf("Error subscribing to subject")
+	}
+
+	// publish
+	if err := conn.Publish(msg); err != nil {
+		t.Fatalf("Error publishing message")
+	}
+
+	for _, sub := range []*hub.Subscription{sub1, sub2} {
+		select {
+		case rec := <-sub.Messages:
+			if rec.ID != msg.ID {
+				t.Fatalf("Received incorrect message")
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Listener timed out")
+		}
+	}
+}
+
+// TestListenWildcard ensures that wildcard subjects follow the NATs rules.
+func TestListenWildcard(t *testing.T) {
+	topic := hub.Topic(uuid.New())
+
+	conn := GetMemoryTestConnection(t, memory.NewBroker())
+	sub, err := conn.Listen(topic.ResWildcard().String())
+	if err != nil {
+		t.Fatalf("Error subscribing to subject")
+	}
+
+	// only the first message matches
+	subjects := []string{topic.ResUnique().String(), topic.Req().String(), topic.String()}
+	for _, subject := range subjects {
+		msg := GenerateMsg(func(m *hub.Message) {
+			m.Topic = subject
+			m.Reply = ""
+		})
+		if err := conn.Publish(msg); err != nil {
+			t.Fatalf("Error publishing message")
+		}
+	}
+
+	select {
+	case rec := <-sub.Messages:
+		if rec.Topic != subjects[0] {
+			t.Fatalf("Received message on unexpected subject: %s", rec.Topic)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Listener timed out")
+	}
+	select {
+	case rec := <-sub.Messages:
+		t.Fatalf("Received message on unexpected subject: %s", rec.Topic)
+	case <-time.After(time.Millisecond * 50):
+	}
+}
+
+// TestPublishNoResponders ensures that requests nobody is subscribed to
+// fail fast, while plain publishes do not.
+func TestPublishNoResponders(t *testing.T) {
+	conn := GetMemoryTestConnection(t, memory.NewBroker())
+
+	if err := conn.Publish(GenerateMsg()); err != hub.ErrNoResponders {
+		t.Fatalf("Expected no responders error, got: %v", err)
+	}
+
+	msg := GenerateMsg(func(m *hub.Message) {
+		m.Reply = ""
+	})
+	if err := conn.Publish(msg); err != nil {
+		t.Fatalf("Error publishing message: %s", err.Error())
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	msg := GenerateMsg(func(m *hub.Message) {
+		m.Reply = ""
+	})
+
+	// subscribe
+	conn := GetMemoryTestConnection(t, memory.NewBroker())
+	sub, err := conn.Listen(msg.Topic)
+	if err != nil {
+		t.Fatalf("Error subscribing to subject")
+	}
+
+	// unsubscribe
+	conn.Unsubscribe(sub.ID)
+	if conn.GetNumActiveSubscriptions() != 0 {
+		t.Fatalf("Subscription still active after unsubscribing")
+	}
+
+	// publish
+	if err := conn.Publish(msg); err != nil {
+		t.Fatalf("Error publishing message")
+	}
+
+	// wait
+	select {
+	case _, ok := <-sub.Messages:
+		if ok {
+			t.Fatalf("Received message after unsubscribing")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Messages channel not closed after unsubscribing")
+	}
+}
+
+func TestClose(t *testing.T) {
+	conn := GetMemoryTestConnection(t, memory.NewBroker())
+	sub, err := conn.Listen(uuid.New())
+	if err != nil {
+		t.Fatalf("Error subscribing to subject")
+	}
+
+	if err := conn.Close(); err != nil {
+		t.Fatalf("Error closing connection: %s", err.Error())
+	}
+	if conn.IsOpen() {
+		t.Fatalf("Connection open after closing")
+	}
+
+	// subscriptions are closed
+	select {
+	case _, ok := <-sub.Messages:
+		if ok {
+			t.Fatalf("Received message after closing")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Messages channel not closed after closing")
+	}
+
+	if err := conn.Publish(GenerateMsg()); err != memory.ErrConnectionClosed {
+		t.Fatalf("Expected closed error publishing, got: %v", err)
+	}
+	if _, err := conn.Subscribe(uuid.New()); err != memory.ErrConnectionClosed {
+		t.Fatalf("Expected closed error subscribing, got: %v", err)
+	}
+}
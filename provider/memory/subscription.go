@@ -0,0 +1,95 @@
+package memory
+
+import (
+	"sync"
+
+	"github.com/jorgeolivero/hub"
+)
+
+// subscription queues messages pushed by the broker and feeds them, in
+// order, to the channel handed out to the bus. Publishers never block on
+// slow subscribers.
+type subscription struct {
+	subject  string
+	group    string
+	messages chan *hub.Message
+
+	lock    sync.Mutex
+	pending []*hub.Message
+	notify  chan struct{}
+	done    chan struct{}
+}
+
+func newSubscription(subject, group string) *subscription {
+	sub := &subscription{
+		subject:  subject,
+		group:    group,
+		messages: make(chan *hub.Message),
+		notify:   make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+
+	go sub.deliver()
+
+	return sub
+}
+
+// groupKey identifies the queue group of the subscription, it is empty for
+// listeners.
+func (s *subscription) groupKey() string {
+	if len(s.group) == 0 {
+		return ""
+	}
+	return s.subject + " " + s.group
+}
+
+func (s *subscription) push(msg *hub.Message) {
+	s.lock.Lock()
+	s.pending = append(s.pending, msg)
+	s.lock.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *subscription) next() *hub.Message {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.pending) == 0 {
+		return nil
+	}
+	msg := s.pending[0]
+	s.pending[0] = nil
+	s.pending = s.pending[1:]
+	return msg
+}
+
+func (s *subscription) deliver() {
+	// when the subscription is closed, ranges over messages end
+	defer close(s.messages)
+
+	for {
+		msg := s.next()
+		if msg == nil {
+			select {
+			case <-s.notify:
+				continue
+			case <-s.done:
+				return
+			}
+		}
+
+		select {
+		case s.messages <- msg:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *subscription) close() {
+	close(s.done)
+}
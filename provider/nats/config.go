@@ -0,0 +1,194 @@
+package nats
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/jorgeolivero/hub/wire"
+	"github.com/nats-io/nats.go"
+)
+
+// ErrInvalidConfig is wrapped by the errors returned by Config.Validate.
+var ErrInvalidConfig = errors.New("Invalid NATs config")
+
+type Config struct {
+	User, Password, Host, Port string
+	Service                    string
+	DefaultTimeout             time.Duration
+	// Encoding of published messages, see package wire. Incoming messages
+	// are decoded whatever their encoding.
+	Encoding wire.Format
+
+	// Servers lists the URLs of the servers of a cluster, such as
+	// "nats://10.0.0.1:4222". When set, Host and Port are ignored.
+	Servers []string
+
+	// Token authenticates with a token instead of a user and password.
+	Token string
+	// CredsFile is the path of a credentials file holding the JWT of the user
+	// and its NKey seed, as used with decentralized authentication.
+	CredsFile string
+	// NKeySeedFile is the path of a file holding the NKey seed of the user.
+	NKeySeedFile string
+
+	// TLS enables TLS when set.
+	TLS *TLSConfig
+
+	// MaxReconnects is the number of attempts made to reconnect before the
+	// connection closes, a negative number retries forever and zero keeps
+	// the NATs client default.
+	MaxReconnects int
+	// ReconnectWait is the delay before the first reconnect attempt, it
+	// doubles with each attempt up to MaxReconnectWait.
+	ReconnectWait    time.Duration
+	MaxReconnectWait time.Duration
+	// ReconnectBufferSize is the number of bytes of publishes buffered
+	// while reconnecting, zero keeps the NATs client default.
+	ReconnectBufferSize int
+}
+
+// TLSConfig holds the paths of the PEM files used to secure the connection.
+// With none of them set, the server is verified against the system roots.
+type TLSConfig struct {
+	// CAFile verifies the server certificate instead of the system roots.
+	CAFile string
+	// CertFile and KeyFile hold the client certificate, for servers
+	// requiring one. They go together.
+	CertFile, KeyFile string
+	// ServerName overrides the name the server certificate is verified
+	// against, defaulting to the host connected to.
+	ServerName string
+}
+
+func DefaultConfig(service string) *Config {
+	return &Config{
+		User:           "",
+		Password:       "",
+		Host:           "localhost",
+		Port:           "4222",
+		Service:        service,
+		DefaultTimeout: time.Second * 5,
+		Encoding:       wire.BINARY,
+
+		MaxReconnects:    -1,
+		ReconnectWait:    time.Millisecond * 250,
+		MaxReconnectWait: time.Second * 10,
+	}
+}
+
+// Validate reports inconsistent configs, such as several authentication
+// methods or a client certificate without its key.
+func (config *Config) Validate() error {
+	if len(config.Servers) == 0 && len(config.Host) == 0 {
+		return fmt.Errorf("%w: no host nor servers", ErrInvalidConfig)
+	}
+	for _, server := range config.Servers {
+		if len(strings.TrimSpace(server)) == 0 {
+			return fmt.Errorf("%w: empty server url", ErrInvalidConfig)
+		}
+	}
+
+	// authentication
+	if len(config.Password) > 0 && len(config.User) == 0 {
+		return fmt.Errorf("%w: password without user", ErrInvalidConfig)
+	}
+	methods := []string{}
+	if len(config.User) > 0 {
+		methods = append(methods, "user")
+	}
+	if len(config.Token) > 0 {
+		methods = append(methods, "token")
+	}
+	if len(config.CredsFile) > 0 {
+		methods = append(methods, "creds file")
+	}
+	if len(config.NKeySeedFile) > 0 {
+		methods = append(methods, "nkey seed")
+	}
+	if len(methods) > 1 {
+		return fmt.Errorf("%w: several authentication methods: %s", ErrInvalidConfig, strings.Join(methods, ", "))
+	}
+
+	// tls
+	if config.TLS != nil && (len(config.TLS.CertFile) > 0) != (len(config.TLS.KeyFile) > 0) {
+		return fmt.Errorf("%w: TLS client certificate and key go together", ErrInvalidConfig)
+	}
+
+	return nil
+}
+
+// options returns the NATs client options for authentication and TLS.
+func (config *Config) options() ([]nats.Option, error) {
+	opts := []nats.Option{}
+
+	switch {
+	case len(config.User) > 0:
+		opts = append(opts, nats.UserInfo(config.User, config.Password))
+	case len(config.Token) > 0:
+		opts = append(opts, nats.Token(config.Token))
+	case len(config.CredsFile) > 0:
+		opts = append(opts, nats.UserCredentials(config.CredsFile))
+	case len(config.NKeySeedFile) > 0:
+		opt, err := nats.NkeyOptionFromSeed(config.NKeySeedFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, opt)
+	}
+
+	if config.TLS != nil {
+		// first, the other options add to this config
+		opts = append(opts, nats.Secure(&tls.Config{
+			MinVersion: tls.VersionTLS12,
+			ServerName: config.TLS.ServerName,
+		}))
+		if len(config.TLS.CAFile) > 0 {
+			opts = append(opts, nats.RootCAs(config.TLS.CAFile))
+		}
+		if len(config.TLS.CertFile) > 0 {
+			opts = append(opts, nats.ClientCert(config.TLS.CertFile, config.TLS.KeyFile))
+		}
+	}
+
+	return opts, nil
+}
+
+// reconnectDelay returns the delay before the reconnect attempt, backing off
+// exponentially with some jitter.
+func (config *Config) reconnectDelay(attempts int) time.Duration {
+	wait := config.ReconnectWait
+	if wait <= 0 {
+		wait = nats.DefaultReconnectWait
+	}
+	for i := 1; i < attempts && (config.MaxReconnectWait <= 0 || wait < config.MaxReconnectWait); i++ {
+		wait *= 2
+	}
+	if config.MaxReconnectWait > 0 && wait > config.MaxReconnectWait {
+		wait = config.MaxReconnectWait
+	}
+
+	// spread reconnects of many clients by up to a fifth of the delay
+	return wait + time.Duration(rand.Int63n(int64(wait)/5+1))
+}
+
+// ConnectionUrl returns the URLs of the servers, separated by commas.
+// Credentials are not part of it, NewConnection sets them from the config.
+func (config *Config) ConnectionUrl() string {
+	if len(config.Servers) > 0 {
+		return strings.Join(config.Servers, ",")
+	}
+
+	scheme := "nats"
+	if config.TLS != nil {
+		scheme = "tls"
+	}
+	port := config.Port
+	if len(port) == 0 {
+		port = "4222"
+	}
+	return fmt.Sprintf("%s://%s:%s", scheme, config.Host, port)
+}
@@ -1,172 +1,282 @@
 package nats
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/apcera/nats"
 	"github.com/jorgeolivero/hub"
+	"github.com/jorgeolivero/hub/wire"
+	"github.com/nats-io/nats.go"
 	"github.com/pborman/uuid"
 )
 
-type Config struct {
-	User, Password, Host, Port string
-	Service                    string
-	DefaultTimeout             time.Duration
-}
-
-func DefaultConfig(service string) *Config {
-	return &Config{
-		User:           "",
-		Password:       "",
-		Host:           "localhost",
-		Port:           "4222",
-		Service:        service,
-		DefaultTimeout: time.Second * 5,
-	}
-}
-
-func (config *Config) ConnectionUrl() string {
-	if config.Host == "localhost" {
-		return nats.DefaultURL
-	}
-	return fmt.Sprintf(
-		"nats://%s:%s@%s:%s",
-		config.User,
-		config.Password,
-		config.Host,
-		config.Port)
-}
-
 type Connection struct {
 	Config        *Config
-	Connection    *nats.EncodedConn
+	Connection    *nats.Conn
 	Subscriptions map[string]*Subscription
+
+	// ReconnectChan receives a value after each reconnect, when nothing is
+	// waiting on it the notification is dropped.
+	//
+	// Deprecated: use OnConnectionEvent.
 	ReconnectChan chan bool
 
 	subscriptionsLock *sync.Mutex
+	closedChan        chan struct{}
+	handlersLock      sync.RWMutex
+	handlers          []func(hub.ConnectionEvent)
 }
 
 type Subscription struct {
 	MsgChan      chan *hub.Message
 	Subscription *nats.Subscription
+
+	// lock is held by callbacks while they deliver, so that MsgChan is
+	// only closed once no callback can send on it anymore.
+	lock     sync.RWMutex
+	isClosed bool
+	done     chan struct{}
 }
 
 // As a matter of course, Nats connections should have a queue group name.
 // However, providing an empty string for group, will allow the client to
 // create singular nats connections on which to subscribe
+//
+// When the connection to the server drops, the client reconnects according
+// to the config. Publishes are buffered meanwhile, and once reconnected the
+// client restores every subscription. State changes are reported to the
+// handlers registered with OnConnectionEvent.
+//
+// The url may list several servers separated by commas, see
+// Config.ConnectionUrl. The config is validated first.
 func NewConnection(url string, config *Config) (*Connection, error) {
-	// set up connetion options
-	reconnectChan := make(chan bool)
-	opts := nats.Options{
-		Url:     url,
-		Timeout: config.DefaultTimeout,
-		ReconnectedCB: func(c *nats.Conn) {
-			reconnectChan <- true
-		},
-		DisconnectedCB: func(c *nats.Conn) {
-			// restart the process
-			panic(fmt.Errorf("nats disconnected"))
-		},
+	if err := config.Validate(); err != nil {
+		return nil, err
 	}
 
-	// connect
-	conn, err := opts.Connect()
+	natsConn := &Connection{
+		Config:            config,
+		Subscriptions:     make(map[string]*Subscription),
+		ReconnectChan:     make(chan bool, 1),
+		subscriptionsLock: &sync.Mutex{},
+		closedChan:        make(chan struct{}),
+	}
+
+	// set up connetion options
+	opts := nats.GetDefaultOptions()
+	for _, server := range strings.Split(url, ",") {
+		opts.Servers = append(opts.Servers, strings.TrimSpace(server))
+	}
+	opts.Timeout = config.DefaultTimeout
+	opts.AllowReconnect = true
+	opts.CustomReconnectDelayCB = config.reconnectDelay
+	if config.MaxReconnects != 0 {
+		opts.MaxReconnect = config.MaxReconnects
+	}
+	if config.ReconnectBufferSize != 0 {
+		opts.ReconnectBufSize = config.ReconnectBufferSize
+	}
+	opts.DisconnectedErrCB = func(c *nats.Conn, err error) {
+		// closing drains then disconnects, that is not worth reporting
+		if c.IsClosed() || c.IsDraining() {
+			return
+		}
+		natsConn.notify(hub.ConnectionEvent{State: hub.StateDisconnected, Err: err})
+	}
+	opts.ReconnectedCB = func(c *nats.Conn) {
+		select {
+		case natsConn.ReconnectChan <- true:
+		default:
+		}
+		natsConn.notify(hub.ConnectionEvent{State: hub.StateReconnected})
+	}
+	opts.ClosedCB = func(c *nats.Conn) {
+		close(natsConn.closedChan)
+		natsConn.notify(hub.ConnectionEvent{State: hub.StateClosed, Err: c.LastError()})
+	}
+	authOpts, err := config.options()
 	if err != nil {
 		return nil, err
 	}
+	for _, opt := range authOpts {
+		if err := opt(&opts); err != nil {
+			return nil, err
+		}
+	}
 
-	// get encoded connection
-	ec, err := nats.NewEncodedConn(conn, nats.GOB_ENCODER)
+	// connect
+	conn, err := opts.Connect()
 	if err != nil {
 		return nil, err
 	}
+	natsConn.Connection = conn
 
-	// wrap
-	natsConn := &Connection{
-		Config:            config,
-		Connection:        ec,
-		Subscriptions:     make(map[string]*Subscription),
-		ReconnectChan:     reconnectChan,
-		subscriptionsLock: &sync.Mutex{},
-	}
 	return natsConn, nil
 }
 
-func (nc *Connection) IsOpen() bool {
-	return !nc.Connection.Conn.IsClosed()
+// OnConnectionEvent registers a handler called when the connection
+// disconnects, reconnects or closes.
+func (nc *Connection) OnConnectionEvent(handler func(hub.ConnectionEvent)) {
+	nc.handlersLock.Lock()
+	defer nc.handlersLock.Unlock()
+
+	nc.handlers = append(nc.handlers, handler)
 }
 
-func (nc *Connection) Listen(subject string) (*hub.Subscription, error) {
-	// create chan
-	msgChan := make(chan *hub.Message)
+func (nc *Connection) notify(event hub.ConnectionEvent) {
+	nc.handlersLock.RLock()
+	defer nc.handlersLock.RUnlock()
 
-	// start subscription
-	sub, err := nc.Connection.Subscribe(subject, func(msg *hub.Message) {
-		msgChan <- msg
-	})
-	if err != nil {
-		return nil, err
+	for _, handler := range nc.handlers {
+		handler(event)
 	}
+}
 
-	// store and return
-	nc.subscriptionsLock.Lock()
-	defer nc.subscriptionsLock.Unlock()
+func (nc *Connection) IsOpen() bool {
+	return !nc.Connection.IsClosed()
+}
 
-	subID := uuid.New()
-	nc.Subscriptions[subID] = &Subscription{
-		MsgChan:      msgChan,
-		Subscription: sub,
-	}
-	return &hub.Subscription{
-		ID:       subID,
-		Messages: msgChan,
-	}, nil
+func (nc *Connection) Listen(subject string) (*hub.Subscription, error) {
+	return nc.subscribe(subject, "")
 }
 
 func (nc *Connection) Subscribe(subject string) (*hub.Subscription, error) {
+	return nc.subscribe(subject, nc.Config.Service)
+}
+
+func (nc *Connection) subscribe(subject, queue string) (*hub.Subscription, error) {
 	// create chan
-	msgChan := make(chan *hub.Message)
+	s := newSubscription()
+
+	// start subscription, an empty queue does not join a group
+	var err error
+	s.Subscription, err = nc.Connection.QueueSubscribe(subject, queue, func(m *nats.Msg) {
+		msg, err := wire.Unmarshal(m.Data)
+		if err != nil {
+			fmt.Printf("Dropped undecodable message on subject [%s]: %s\n", m.Subject, err.Error())
+			return
+		}
 
-	// start subscription
-	sub, err := nc.Connection.QueueSubscribe(subject, nc.Config.Service, func(msg *hub.Message) {
 		// push it through the message chan
-		msgChan <- msg
+		s.deliver(msg)
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	// store and return
+	return nc.register(s), nil
+}
+
+// register stores the subscription so it can be unsubscribed by ID.
+func (nc *Connection) register(s *Subscription) *hub.Subscription {
 	nc.subscriptionsLock.Lock()
 	defer nc.subscriptionsLock.Unlock()
 
 	subID := uuid.New()
-	nc.Subscriptions[subID] = &Subscription{
-		MsgChan:      msgChan,
-		Subscription: sub,
-	}
+	nc.Subscriptions[subID] = s
 	return &hub.Subscription{
 		ID:       subID,
-		Messages: msgChan,
-	}, nil
+		Messages: s.MsgChan,
+	}
+}
+
+func newSubscription() *Subscription {
+	return &Subscription{
+		MsgChan: make(chan *hub.Message),
+		done:    make(chan struct{}),
+	}
 }
 
+func (s *Subscription) deliver(msg *hub.Message) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if s.isClosed {
+		return
+	}
+	select {
+	case s.MsgChan <- msg:
+	case <-s.done:
+	}
+}
+
+func (s *Subscription) close() error {
+	err := s.Subscription.Unsubscribe()
+
+	// release blocked callbacks, then wait for them to return
+	close(s.done)
+	s.lock.Lock()
+	s.isClosed = true
+	close(s.MsgChan)
+	s.lock.Unlock()
+
+	return err
+}
+
+// Unsubscribe closes the subscriptions. Unsubscribing while the connection
+// drains or closes is expected to fail, other failures are logged.
 func (nc *Connection) Unsubscribe(subscriptionIds ...string) {
+	nc.subscriptionsLock.Lock()
+	defer nc.subscriptionsLock.Unlock()
+
 	for _, sid := range subscriptionIds {
 		if sub, ok := nc.Subscriptions[sid]; ok {
-			close(sub.MsgChan)
-			if err := sub.Subscription.Unsubscribe(); err != nil {
-				panic(err)
+			if err := sub.close(); err != nil && !isClosing(err) {
+				fmt.Printf("Error unsubscribing [%s] from subject [%s]: %s\n", sid, sub.Subscription.Subject, err.Error())
 			}
 			delete(nc.Subscriptions, sid)
 		}
 	}
 }
 
+// isClosing reports whether the error comes from a subscription or a
+// connection already going away.
+func isClosing(err error) bool {
+	return errors.Is(err, nats.ErrConnectionClosed) ||
+		errors.Is(err, nats.ErrConnectionDraining) ||
+		errors.Is(err, nats.ErrBadSubscription)
+}
+
+// Close drains the connection, delivering the messages already received by
+// its subscriptions and flushing pending publishes, then closes it.
+func (nc *Connection) Close() error {
+	// the connection may have closed on its own, after giving up on
+	// reconnecting
+	if !nc.Connection.IsClosed() {
+		// draining while reconnecting closes the connection right away,
+		// and the connection may close on its own meanwhile
+		err := nc.Connection.Drain()
+		if err != nil && err != nats.ErrConnectionReconnecting && err != nats.ErrConnectionClosed {
+			return err
+		}
+		<-nc.closedChan
+	}
+
+	// close what remains of the subscriptions
+	nc.subscriptionsLock.Lock()
+	defer nc.subscriptionsLock.Unlock()
+
+	for sid, sub := range nc.Subscriptions {
+		sub.close()
+		delete(nc.Subscriptions, sid)
+	}
+	return nil
+}
+
 func (nc *Connection) Publish(msg *hub.Message) error {
-	return nc.Connection.Publish(msg.Topic, msg)
+	encoding := nc.Config.Encoding
+	if len(encoding) == 0 {
+		encoding = wire.BINARY
+	}
+
+	data, err := wire.Marshal(msg, encoding)
+	if err != nil {
+		return err
+	}
+	return nc.Connection.Publish(msg.Topic, data)
 }
 
 func (nc *Connection) Request(msg *hub.Message) error {
@@ -189,3 +299,7 @@ func (nc *Connection) GetReconnectNotifyChan() chan bool {
 func (nc *Connection) ServiceNameIsSet() bool {
 	return len(nc.Config.Service) > 0
 }
+
+func (nc *Connection) ServiceName() string {
+	return nc.Config.Service
+}
@@ -3,6 +3,7 @@
 package nats_test
 
 import (
+	"errors"
 	"testing"
 	"time"
 
@@ -11,7 +12,7 @@ import (
 	"github.com/pborman/uuid"
 )
 
-func GetNatsTestConnection(t *testing.T) *nats.Connection {
+func GetNatsTestConnection(t testing.TB) *nats.Connection {
 	cfg := nats.DefaultConfig("CONNECTION_TEST")
 	conn, err := nats.NewConnection(cfg.ConnectionUrl(), cfg)
 	if err != nil {
@@ -37,6 +38,63 @@ func GenerateMsg(opts ...func(m *hub.Message)) *hub.Message {
 	return msg
 }
 
+func TestConnectionUrl(t *testing.T) {
+	cfg := nats.DefaultConfig("CONNECTION_TEST")
+	cfg.Host = "nats.example.com"
+	cfg.User, cfg.Password = "user", "secret"
+	if url := cfg.ConnectionUrl(); url != "nats://nats.example.com:4222" {
+		t.Fatalf("Unexpected url: %s", url)
+	}
+
+	cfg.TLS = &nats.TLSConfig{}
+	if url := cfg.ConnectionUrl(); url != "tls://nats.example.com:4222" {
+		t.Fatalf("Unexpected url: %s", url)
+	}
+
+	cfg.Servers = []string{"nats://10.0.0.1:4222", "nats://10.0.0.2:4222"}
+	if url := cfg.ConnectionUrl(); url != "nats://10.0.0.1:4222,nats://10.0.0.2:4222" {
+		t.Fatalf("Unexpected url: %s", url)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := map[string]func(cfg *nats.Config){
+		"no host": func(cfg *nats.Config) {
+			cfg.Host = ""
+		},
+		"empty server": func(cfg *nats.Config) {
+			cfg.Servers = []string{"nats://10.0.0.1:4222", ""}
+		},
+		"password without user": func(cfg *nats.Config) {
+			cfg.Password = "secret"
+		},
+		"user and token": func(cfg *nats.Config) {
+			cfg.User, cfg.Token = "user", "token"
+		},
+		"creds and nkey": func(cfg *nats.Config) {
+			cfg.CredsFile, cfg.NKeySeedFile = "user.creds", "user.nk"
+		},
+		"cert without key": func(cfg *nats.Config) {
+			cfg.TLS = &nats.TLSConfig{CertFile: "client.pem"}
+		},
+	}
+
+	for name, modify := range tests {
+		cfg := nats.DefaultConfig("CONNECTION_TEST")
+		modify(cfg)
+		if err := cfg.Validate(); !errors.Is(err, nats.ErrInvalidConfig) {
+			t.Fatalf("%s: expected invalid config, got: %v", name, err)
+		}
+	}
+
+	cfg := nats.DefaultConfig("CONNECTION_TEST")
+	cfg.Token = "token"
+	cfg.TLS = &nats.TLSConfig{CAFile: "ca.pem", CertFile: "client.pem", KeyFile: "client-key.pem"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Unexpected error validating config: %s", err.Error())
+	}
+}
+
 func TestCreatingConnection(t *testing.T) {
 	conn := GetNatsTestConnection(t)
 	if !conn.IsOpen() {
@@ -191,6 +249,35 @@ func TestMultiListenTopic(t *testing.T) {
 	}
 }
 
+// TestPublishHeaders ensures that message headers are transported.
+func TestPublishHeaders(t *testing.T) {
+	msg := GenerateMsg(func(m *hub.Message) {
+		m.Headers = map[string]string{"correlation-id": uuid.New()}
+	})
+
+	// subscribe
+	conn := GetNatsTestConnection(t)
+	sub, err := conn.Subscribe(msg.Topic)
+	if err != nil {
+		t.Fatalf("Error subscribing to subject")
+	}
+
+	// publish
+	if err := conn.Publish(msg); err != nil {
+		t.Fatalf("Error publishing message")
+	}
+
+	// wait
+	select {
+	case rec := <-sub.Messages:
+		if rec.Header("correlation-id") != msg.Header("correlation-id") {
+			t.Fatalf("Headers not transported: %v", rec.Headers)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Subscription timed out")
+	}
+}
+
 func TestUnsubscribe(t *testing.T) {
 	msg := GenerateMsg()
 
@@ -234,3 +321,56 @@ func TestUnsubscribe(t *testing.T) {
 		break
 	}
 }
+
+func BenchmarkRequestSharedInbox(b *testing.B) {
+	benchmarkRequest(b, true)
+}
+
+func BenchmarkRequestPerRequestInbox(b *testing.B) {
+	benchmarkRequest(b, false)
+}
+
+func benchmarkRequest(b *testing.B, sharedInbox bool) {
+	bus := hub.NewBus(GetNatsTestConnection(b), hub.JSON)
+	defer bus.Close()
+	bus.SharedInbox = sharedInbox
+
+	topic := hub.Topic(uuid.New())
+	if _, err := bus.Subscribe(topic.Req(), func(c *hub.Context) {
+		c.Respond("pong")
+	}); err != nil {
+		b.Fatalf("Error subscribing: %s", err.Error())
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			var res string
+			if err := bus.Request(topic, "ping", &res); err != nil {
+				b.Errorf("Error requesting: %s", err.Error())
+				return
+			}
+		}
+	})
+}
+
+func TestUnsubscribeWhileDraining(t *testing.T) {
+	conn := GetNatsTestConnection(t)
+	sub, err := conn.Listen(uuid.New())
+	if err != nil {
+		t.Fatalf("Error subscribing to subject")
+	}
+
+	// unsubscribing races the drain started by closing, it must not panic
+	if err := conn.Connection.Drain(); err != nil {
+		t.Fatalf("Error draining: %s", err.Error())
+	}
+	conn.Unsubscribe(sub.ID)
+
+	if conn.GetNumActiveSubscriptions() != 0 {
+		t.Fatalf("Subscription not removed")
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatalf("Error closing: %s", err.Error())
+	}
+}
@@ -0,0 +1,287 @@
+package nats
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jorgeolivero/hub"
+	"github.com/jorgeolivero/hub/wire"
+	"github.com/nats-io/nats.go"
+)
+
+// JetStreamConfig configures the stream backing a JetStreamConnection and
+// the consumers created for its subscriptions.
+type JetStreamConfig struct {
+	Stream StreamConfig
+	// AckWait is how long the server waits for a message to be acknowledged
+	// before redelivering it.
+	AckWait time.Duration
+	// MaxDeliver is the number of times a message is delivered before the
+	// server gives up on it, a negative number redelivers forever.
+	MaxDeliver int
+}
+
+// StreamConfig describes a JetStream stream. Limits left to zero are
+// unlimited.
+type StreamConfig struct {
+	Name string
+	// Subjects stored by the stream, wildcards are allowed. They should not
+	// cover response subjects, such as "orders.>" covering "orders.RES.*",
+	// those are never made durable.
+	Subjects []string
+	MaxAge   time.Duration
+	MaxMsgs  int64
+	MaxBytes int64
+	Replicas int
+	// Memory stores messages in memory rather than in files.
+	Memory bool
+}
+
+func DefaultJetStreamConfig(stream string, subjects ...string) *JetStreamConfig {
+	return &JetStreamConfig{
+		Stream: StreamConfig{
+			Name:     stream,
+			Subjects: subjects,
+		},
+		AckWait:    time.Second * 30,
+		MaxDeliver: 5,
+	}
+}
+
+// Validate reports configs missing the stream name or subjects.
+func (config *JetStreamConfig) Validate() error {
+	if len(config.Stream.Name) == 0 {
+		return fmt.Errorf("%w: no stream name", ErrInvalidConfig)
+	}
+	if strings.ContainsAny(config.Stream.Name, ".*> ") {
+		return fmt.Errorf("%w: invalid stream name %q", ErrInvalidConfig, config.Stream.Name)
+	}
+	if len(config.Stream.Subjects) == 0 {
+		return fmt.Errorf("%w: no stream subjects", ErrInvalidConfig)
+	}
+	return nil
+}
+
+func (config *JetStreamConfig) streamConfig() *nats.StreamConfig {
+	storage := nats.FileStorage
+	if config.Stream.Memory {
+		storage = nats.MemoryStorage
+	}
+	return &nats.StreamConfig{
+		Name:     config.Stream.Name,
+		Subjects: config.Stream.Subjects,
+		MaxAge:   config.Stream.MaxAge,
+		MaxMsgs:  limit(config.Stream.MaxMsgs),
+		MaxBytes: limit(config.Stream.MaxBytes),
+		Replicas: config.Stream.Replicas,
+		Storage:  storage,
+	}
+}
+
+// limit converts a zero limit to the unlimited value of JetStream.
+func limit(n int64) int64 {
+	if n == 0 {
+		return -1
+	}
+	return n
+}
+
+// JetStreamConnection is a Connection delivering the messages of the
+// subjects of its stream at least once. Subscribe creates a durable consumer
+// per subject and service, so messages published while every node of the
+// service is down are delivered once one comes back. Messages must be
+// acknowledged, see hub.Context.Ack and hub.WithAckPolicy, or they are
+// redelivered after AckWait.
+//
+// Subjects outside of the stream, responses, and Listen use core NATs.
+type JetStreamConnection struct {
+	*Connection
+	JetStreamConfig *JetStreamConfig
+
+	js nats.JetStreamContext
+}
+
+// NewJetStreamConnection connects like NewConnection, then creates or
+// updates the stream.
+func NewJetStreamConnection(url string, config *Config, jsConfig *JetStreamConfig) (*JetStreamConnection, error) {
+	if err := jsConfig.Validate(); err != nil {
+		return nil, err
+	}
+
+	conn, err := NewConnection(url, config)
+	if err != nil {
+		return nil, err
+	}
+	js, err := conn.Connection.JetStream()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	jc := &JetStreamConnection{
+		Connection:      conn,
+		JetStreamConfig: jsConfig,
+		js:              js,
+	}
+	if err := jc.ensureStream(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return jc, nil
+}
+
+func (jc *JetStreamConnection) ensureStream() error {
+	cfg := jc.JetStreamConfig.streamConfig()
+
+	_, err := jc.js.StreamInfo(cfg.Name)
+	switch {
+	case errors.Is(err, nats.ErrStreamNotFound):
+		_, err = jc.js.AddStream(cfg)
+	case err == nil:
+		_, err = jc.js.UpdateStream(cfg)
+	}
+	if err != nil {
+		return fmt.Errorf("Error configuring stream [%s]: %w", cfg.Name, err)
+	}
+	return nil
+}
+
+// Subscribe consumes the subject through a durable consumer shared by the
+// nodes of the service, when the subject belongs to the stream.
+func (jc *JetStreamConnection) Subscribe(subject string) (*hub.Subscription, error) {
+	if !jc.isDurable(subject) {
+		return jc.Connection.Subscribe(subject)
+	}
+
+	name, err := jc.ensureConsumer(subject)
+	if err != nil {
+		return nil, err
+	}
+
+	// bound to an existing consumer, unsubscribing keeps it
+	s := newSubscription()
+	s.Subscription, err = jc.js.QueueSubscribe(subject, jc.Config.Service, func(m *nats.Msg) {
+		msg, err := wire.Unmarshal(m.Data)
+		if err != nil {
+			fmt.Printf("Dropped undecodable message on subject [%s]: %s\n", m.Subject, err.Error())
+			// it will not decode any better next time
+			m.Term()
+			return
+		}
+		msg.Acker = &jetStreamAcker{msg: m}
+
+		// push it through the message chan
+		s.deliver(msg)
+	}, nats.Bind(jc.JetStreamConfig.Stream.Name, name), nats.ManualAck())
+	if err != nil {
+		return nil, err
+	}
+
+	return jc.register(s), nil
+}
+
+// ensureConsumer creates the durable consumer of the subject for the
+// service, unless it exists already.
+func (jc *JetStreamConnection) ensureConsumer(subject string) (string, error) {
+	stream := jc.JetStreamConfig.Stream.Name
+	name := durableName(jc.Config.Service, subject)
+
+	_, err := jc.js.ConsumerInfo(stream, name)
+	if err == nil {
+		return name, nil
+	}
+	if !errors.Is(err, nats.ErrConsumerNotFound) {
+		return "", err
+	}
+
+	_, err = jc.js.AddConsumer(stream, &nats.ConsumerConfig{
+		Durable:        name,
+		FilterSubject:  subject,
+		DeliverSubject: nats.NewInbox(),
+		DeliverGroup:   jc.Config.Service,
+		DeliverPolicy:  nats.DeliverAllPolicy,
+		AckPolicy:      nats.AckExplicitPolicy,
+		AckWait:        jc.JetStreamConfig.AckWait,
+		MaxDeliver:     jc.JetStreamConfig.MaxDeliver,
+	})
+	if err != nil {
+		return "", fmt.Errorf("Error creating consumer [%s]: %w", name, err)
+	}
+	return name, nil
+}
+
+// Publish stores the message in the stream when its subject belongs to it,
+// waiting for the server to acknowledge it.
+func (jc *JetStreamConnection) Publish(msg *hub.Message) error {
+	if !jc.isDurable(msg.Topic) {
+		return jc.Connection.Publish(msg)
+	}
+
+	encoding := jc.Config.Encoding
+	if len(encoding) == 0 {
+		encoding = wire.BINARY
+	}
+
+	data, err := wire.Marshal(msg, encoding)
+	if err != nil {
+		return err
+	}
+	_, err = jc.js.Publish(msg.Topic, data)
+	return err
+}
+
+func (jc *JetStreamConnection) Request(msg *hub.Message) error {
+	return jc.Publish(msg)
+}
+
+// isDurable reports whether the subject belongs to the stream, responses
+// never do.
+func (jc *JetStreamConnection) isDurable(subject string) bool {
+	for _, token := range strings.Split(subject, ".") {
+		if token == "RES" {
+			return false
+		}
+	}
+	for _, pattern := range jc.JetStreamConfig.Stream.Subjects {
+		if hub.Topic(pattern).Matches(subject) {
+			return true
+		}
+	}
+	return false
+}
+
+// durableName derives a consumer name from the service and subject, names
+// cannot hold the characters of subjects.
+func durableName(service, subject string) string {
+	name := strings.NewReplacer(".", "_", "*", "STAR", ">", "ALL", " ", "_").Replace(subject)
+	if len(service) == 0 {
+		return name
+	}
+	return service + "_" + name
+}
+
+// jetStreamAcker settles the delivery of a JetStream message.
+type jetStreamAcker struct {
+	msg *nats.Msg
+}
+
+func (a *jetStreamAcker) Ack() error {
+	return a.msg.Ack()
+}
+
+func (a *jetStreamAcker) Nak(delay time.Duration) error {
+	if delay > 0 {
+		return a.msg.NakWithDelay(delay)
+	}
+	return a.msg.Nak()
+}
+
+func (a *jetStreamAcker) Term() error {
+	return a.msg.Term()
+}
+
+func (a *jetStreamAcker) InProgress() error {
+	return a.msg.InProgress()
+}
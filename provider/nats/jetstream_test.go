@@ -0,0 +1,154 @@
+package nats_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jorgeolivero/hub"
+	"github.com/jorgeolivero/hub/provider/nats"
+	"github.com/nats-io/nats-server/v2/server"
+	natsserver "github.com/nats-io/nats-server/v2/test"
+	"github.com/pborman/uuid"
+)
+
+// RunJetStreamServer starts an embedded server with JetStream enabled, shut
+// down at the end of the test.
+func RunJetStreamServer(t *testing.T) *server.Server {
+	opts := natsserver.DefaultTestOptions
+	opts.Port = -1
+	opts.JetStream = true
+	opts.StoreDir = t.TempDir()
+
+	s := natsserver.RunServer(&opts)
+	t.Cleanup(s.Shutdown)
+	return s
+}
+
+func GetJetStreamTestConnection(t *testing.T, s *server.Server, jsConfig *nats.JetStreamConfig) *nats.JetStreamConnection {
+	cfg := nats.DefaultConfig("JETSTREAM_TEST")
+	conn, err := nats.NewJetStreamConnection(s.ClientURL(), cfg, jsConfig)
+	if err != nil {
+		t.Fatalf("Error creating JetStream connection: %s", err.Error())
+	}
+	return conn
+}
+
+func TestJetStreamConfigValidate(t *testing.T) {
+	if err := nats.DefaultJetStreamConfig("", "orders.>").Validate(); err == nil {
+		t.Fatalf("Expected an error without stream name")
+	}
+	if err := nats.DefaultJetStreamConfig("ORDERS").Validate(); err == nil {
+		t.Fatalf("Expected an error without stream subjects")
+	}
+}
+
+// TestJetStreamDurable ensures that messages published while no node of the
+// service is subscribed are delivered once one subscribes again.
+func TestJetStreamDurable(t *testing.T) {
+	s := RunJetStreamServer(t)
+	jsConfig := nats.DefaultJetStreamConfig("ORDERS", "orders.>")
+	topic := hub.Topic("orders.created")
+
+	// subscribe, then leave
+	bus := hub.NewBus(GetJetStreamTestConnection(t, s, jsConfig), hub.JSON)
+	if _, err := bus.Subscribe(topic, func(c *hub.Context) {}); err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+	if err := bus.Drain(context.Background()); err != nil {
+		t.Fatalf("Error draining bus: %s", err.Error())
+	}
+
+	// publish while nobody is subscribed
+	publisher := hub.NewBus(GetJetStreamTestConnection(t, s, jsConfig), hub.JSON)
+	defer publisher.Close()
+	id := uuid.New()
+	if err := publisher.Publish(topic, id); err != nil {
+		t.Fatalf("Error publishing: %s", err.Error())
+	}
+
+	// come back
+	received := make(chan string, 1)
+	bus = hub.NewBus(GetJetStreamTestConnection(t, s, jsConfig), hub.JSON)
+	defer bus.Close()
+	if _, err := bus.Subscribe(topic, func(c *hub.Context) {
+		var got string
+		c.Bind(&got)
+		received <- got
+	}); err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+
+	select {
+	case got := <-received:
+		if got != id {
+			t.Fatalf("Expected: %s Got: %s", id, got)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatalf("Message published while unsubscribed was not delivered")
+	}
+}
+
+// TestJetStreamRedelivery ensures that naked messages are redelivered until
+// the max deliver limit.
+func TestJetStreamRedelivery(t *testing.T) {
+	s := RunJetStreamServer(t)
+	jsConfig := nats.DefaultJetStreamConfig("PAYMENTS", "payments.>")
+	jsConfig.MaxDeliver = 3
+	topic := hub.Topic("payments.failed")
+
+	bus := hub.NewBus(GetJetStreamTestConnection(t, s, jsConfig), hub.JSON)
+	defer bus.Close()
+
+	deliveries := make(chan struct{}, 10)
+	if _, err := bus.Subscribe(topic, func(c *hub.Context) {
+		deliveries <- struct{}{}
+		if err := c.Nak(0); err != nil {
+			t.Errorf("Error naking message: %s", err.Error())
+		}
+	}); err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+
+	if err := bus.Publish(topic, "payment"); err != nil {
+		t.Fatalf("Error publishing: %s", err.Error())
+	}
+
+	for i := 0; i < jsConfig.MaxDeliver; i++ {
+		select {
+		case <-deliveries:
+		case <-time.After(time.Second * 3):
+			t.Fatalf("Delivery %d did not happen", i+1)
+		}
+	}
+	select {
+	case <-deliveries:
+		t.Fatalf("Message delivered more than %d times", jsConfig.MaxDeliver)
+	case <-time.After(time.Millisecond * 200):
+	}
+}
+
+// TestJetStreamRequest ensures that requests get their responses, which do
+// not go through the stream.
+func TestJetStreamRequest(t *testing.T) {
+	s := RunJetStreamServer(t)
+	jsConfig := nats.DefaultJetStreamConfig("QUOTES", "quotes.>")
+	topic := hub.Topic("quotes")
+
+	bus := hub.NewBus(GetJetStreamTestConnection(t, s, jsConfig), hub.JSON)
+	defer bus.Close()
+
+	if _, err := bus.Subscribe(topic.Req(), func(c *hub.Context) {
+		c.Respond("quote")
+	}); err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+
+	var res string
+	if err := bus.Request(topic, "quote?", &res); err != nil {
+		t.Fatalf("Error requesting: %s", err.Error())
+	}
+	if res != "quote" {
+		t.Fatalf("Expected: quote Got: %s", res)
+	}
+}
@@ -0,0 +1,121 @@
+package nats_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jorgeolivero/hub"
+	"github.com/jorgeolivero/hub/provider/nats"
+	natsserver "github.com/nats-io/nats-server/v2/test"
+)
+
+// ExpectState waits for the connection event with the state.
+func ExpectState(t *testing.T, events chan hub.ConnectionEvent, state hub.ConnectionState) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-events:
+			if event.State == state {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("Connection never reported state %s", state)
+		}
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	opts := natsserver.DefaultTestOptions
+	opts.Port = -1
+	s := natsserver.RunServer(&opts)
+	opts.Port = s.Addr().(*net.TCPAddr).Port
+	defer func() {
+		s.Shutdown()
+	}()
+
+	cfg := nats.DefaultConfig("RECONNECT_TEST")
+	cfg.ReconnectWait = time.Millisecond * 50
+	cfg.MaxReconnectWait = time.Millisecond * 100
+	conn, err := nats.NewConnection(s.ClientURL(), cfg)
+	if err != nil {
+		t.Fatalf("Error creating NATs connection: %s", err.Error())
+	}
+	defer conn.Close()
+
+	events := make(chan hub.ConnectionEvent, 16)
+	conn.OnConnectionEvent(func(event hub.ConnectionEvent) {
+		events <- event
+	})
+
+	msg := GenerateMsg()
+	sub, err := conn.Listen(msg.Topic)
+	if err != nil {
+		t.Fatalf("Error subscribing to subject")
+	}
+
+	// restart the server on the same port
+	s.Shutdown()
+	ExpectState(t, events, hub.StateDisconnected)
+	s = natsserver.RunServer(&opts)
+	ExpectState(t, events, hub.StateReconnected)
+
+	// the subscription was restored
+	if err := conn.Publish(msg); err != nil {
+		t.Fatalf("Error publishing message: %s", err.Error())
+	}
+	select {
+	case rec := <-sub.Messages:
+		if rec.ID != msg.ID {
+			t.Fatalf("Received incorrect message")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Subscription not restored after reconnecting")
+	}
+
+	if !conn.IsOpen() {
+		t.Fatalf("Connection should be open")
+	}
+}
+
+func TestCloseWhileReconnecting(t *testing.T) {
+	opts := natsserver.DefaultTestOptions
+	opts.Port = -1
+	s := natsserver.RunServer(&opts)
+
+	cfg := nats.DefaultConfig("RECONNECT_TEST")
+	conn, err := nats.NewConnection(s.ClientURL(), cfg)
+	if err != nil {
+		t.Fatalf("Error creating NATs connection: %s", err.Error())
+	}
+
+	events := make(chan hub.ConnectionEvent, 16)
+	conn.OnConnectionEvent(func(event hub.ConnectionEvent) {
+		events <- event
+	})
+	sub, err := conn.Listen(GenerateMsg().Topic)
+	if err != nil {
+		t.Fatalf("Error subscribing to subject")
+	}
+
+	// the server never comes back, the connection keeps reconnecting
+	s.Shutdown()
+	ExpectState(t, events, hub.StateDisconnected)
+
+	if err := conn.Close(); err != nil {
+		t.Fatalf("Error closing: %s", err.Error())
+	}
+	if conn.IsOpen() {
+		t.Fatalf("Connection should be closed")
+	}
+
+	// readers of the subscriptions are released
+	select {
+	case _, ok := <-sub.Messages:
+		if ok {
+			t.Fatalf("Received a message after closing")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Subscription not closed")
+	}
+}
@@ -0,0 +1,345 @@
+package hub
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/pborman/uuid"
+)
+
+// Headers set on messages published to a dead-letter topic.
+const (
+	// HeaderDeadLetterTopic holds the topic the message was published to.
+	HeaderDeadLetterTopic = "Dead-Letter-Topic"
+	// HeaderDeadLetterError holds the error of the last attempt.
+	HeaderDeadLetterError = "Dead-Letter-Error"
+	// HeaderDeadLetterAttempts holds the number of attempts made.
+	HeaderDeadLetterAttempts = "Dead-Letter-Attempts"
+	// HeaderDeadLetterReplays holds the number of times the message was
+	// replayed, see ReplayDeadLetters. It is kept on the replayed message.
+	HeaderDeadLetterReplays = "Dead-Letter-Replays"
+	// HeaderDeadLetterPass identifies the last replay pass the message was
+	// replayed by, see ReplayDeadLetters.
+	HeaderDeadLetterPass = "Dead-Letter-Pass"
+)
+
+// RetryPolicy runs the handler of a message again when it fails, that is
+// when it panics or responds with an error. Error responses are held back
+// until the last attempt, so requesters only receive the final one.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times the handler runs, the first one
+	// included.
+	MaxAttempts int
+	// Backoff is the delay before the second attempt, it doubles with each
+	// attempt up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Jitter shortens each delay by a random fraction of up to Jitter,
+	// between 0 and 1.
+	Jitter float64
+}
+
+// ExponentialBackoff returns a policy making up to maxAttempts attempts,
+// doubling the delay between them from backoff up to maxBackoff.
+func ExponentialBackoff(maxAttempts int, backoff, maxBackoff time.Duration) RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: maxAttempts,
+		Backoff:     backoff,
+		MaxBackoff:  maxBackoff,
+		Jitter:      0.2,
+	}
+}
+
+// delay returns the delay following the attempt.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	wait := p.Backoff
+	for i := 1; i < attempt && (p.MaxBackoff <= 0 || wait < p.MaxBackoff); i++ {
+		wait *= 2
+	}
+	if p.MaxBackoff > 0 && wait > p.MaxBackoff {
+		wait = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		wait -= time.Duration(float64(wait) * p.Jitter * rand.Float64())
+	}
+	return wait
+}
+
+// attempt holds the error response of a failed attempt.
+type attempt struct {
+	topic string
+	err   error
+}
+
+// withRetries runs the handler according to the retry policy of the
+// subscription, then publishes messages that keep failing to its dead-letter
+// topic.
+func (b *Bus) withRetries(handler MessageHandler, opts *SubscriptionOptions) MessageHandler {
+	if opts.Retry.MaxAttempts <= 1 && len(opts.DeadLetter) == 0 {
+		return handler
+	}
+
+	return func(c *Context) {
+		var failure *attempt
+		attempts := 1
+		for ; ; attempts++ {
+			failure = c.try(handler)
+			if failure == nil {
+				return
+			}
+			if attempts >= opts.Retry.MaxAttempts {
+				break
+			}
+
+			fmt.Printf("Handler for topic [%s] failed attempt %d: %s\n", c.Topic(), attempts, failure.err.Error())
+			if !sleep(c.Context(), opts.Retry.delay(attempts)) {
+				break
+			}
+		}
+
+		// out of attempts, dead letter the message and answer with the last
+		// error
+		if len(opts.DeadLetter) > 0 {
+			if err := b.deadLetter(c, opts.DeadLetter, failure.err, attempts); err != nil {
+				fmt.Printf("Error dead lettering message [%s] on topic [%s]: %s\n", c.MessageID(), c.Topic(), err.Error())
+			} else if c.ackPolicy != AckManual {
+				c.Ack()
+			}
+		}
+		if len(failure.topic) > 0 {
+			c.respondError(failure.topic, failure.err)
+		}
+	}
+}
+
+// sleep waits for the duration, unless the context is done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// try runs the handler once, returning the error it responded with or
+// panicked with.
+func (c *Context) try(handler MessageHandler) (failure *attempt) {
+	c.attempt = &attempt{}
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Printf("Handler for topic [%s] panicked: %v\n", c.Topic(), r)
+			failure = c.attempt
+			failure.err = fmt.Errorf("%w: %v", ErrHandlerPanic, r)
+			if c.IsReplyable() {
+				failure.topic = c.message.Reply
+			}
+		}
+		c.attempt = nil
+	}()
+
+	handler(c)
+	if c.attempt.err == nil {
+		return nil
+	}
+	return c.attempt
+}
+
+// deadLetter publishes the message to the dead-letter topic, along with
+// the reason it failed.
+func (b *Bus) deadLetter(c *Context, topic Topic, err error, attempts int) error {
+	msg := NewDefaultMessage(func(m *Message) {
+		m.Topic = topic.String()
+		m.ContentType = c.message.ContentType
+		m.Headers = c.message.copyHeaders()
+		m.Payload.Data = c.message.Payload.Data
+		m.SetHeader(HeaderDeadLetterTopic, c.message.Topic)
+		m.SetHeader(HeaderDeadLetterError, err.Error())
+		m.SetHeader(HeaderDeadLetterAttempts, strconv.Itoa(attempts))
+	})
+	return b.Connection.Publish(msg)
+}
+
+// ReplayOptions bound a replay of dead letters, see ReplayDeadLetters. It
+// always stops once its context is done.
+type ReplayOptions struct {
+	// Limit stops replaying after that many dead letters, zero means no
+	// limit.
+	Limit int
+
+	// Quiescence stops replaying once no dead letter arrived for that long,
+	// the bus DefaultTimeout when zero.
+	Quiescence time.Duration
+}
+
+type ReplayOption func(o *ReplayOptions)
+
+// WithReplayLimit stops replaying after n dead letters.
+func WithReplayLimit(n int) ReplayOption {
+	return func(o *ReplayOptions) {
+		o.Limit = n
+	}
+}
+
+// WithReplayQuiescence stops replaying once no dead letter arrived for the
+// duration.
+func WithReplayQuiescence(d time.Duration) ReplayOption {
+	return func(o *ReplayOptions) {
+		o.Quiescence = d
+	}
+}
+
+// ReplayDeadLetters makes a single pass over the dead-letter topic,
+// publishing the messages it receives back onto the topic they were dead
+// lettered from, without the dead-letter headers. The pass stops once the
+// options say so, returning the number of messages replayed, or once the
+// context is done, along with an error wrapping ErrTimeout or ErrCanceled.
+//
+// Replayed messages carry HeaderDeadLetterReplays and HeaderDeadLetterPass.
+// Those failing again during the same pass are not replayed a second time,
+// nor do they count towards its quiescence: once the pass stops they are
+// published back onto the dead-letter topic, for a later pass to replay.
+// Dead letters published while no pass runs are dropped, unless the
+// provider keeps messages, as JetStream does.
+func (b *Bus) ReplayDeadLetters(ctx context.Context, topic Topic, opts ...ReplayOption) (int, error) {
+	if b.IsClosed() {
+		return 0, ErrClosed
+	}
+	if err := contextError(ctx); err != nil {
+		return 0, err
+	}
+
+	o := &ReplayOptions{
+		Quiescence: b.DefaultTimeout,
+	}
+	for _, f := range opts {
+		f(o)
+	}
+
+	pass := &replayPass{
+		id:     uuid.New(),
+		failed: map[string]*Message{},
+	}
+	sub, err := b.Connection.Subscribe(topic.String())
+	if err != nil {
+		return 0, err
+	}
+	// requeue once unsubscribed, so the pass does not receive them again
+	defer b.requeue(topic, pass)
+	defer b.Connection.Unsubscribe(sub.ID)
+
+	timer := time.NewTimer(o.Quiescence)
+	defer timer.Stop()
+
+	replayed := 0
+	for o.Limit <= 0 || replayed < o.Limit {
+		select {
+		case msg, ok := <-sub.Messages:
+			if !ok {
+				return replayed, ErrClosed
+			}
+			if msg.Header(HeaderDeadLetterPass) == pass.id {
+				// failed again, not a dead letter the pass is waiting for
+				if _, ok := pass.failed[msg.ID]; !ok {
+					pass.failed[msg.ID] = msg
+					pass.order = append(pass.order, msg.ID)
+				}
+				continue
+			}
+			if b.replay(msg, pass.id) {
+				replayed++
+			}
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(o.Quiescence)
+		case <-timer.C:
+			return replayed, nil
+		case <-ctx.Done():
+			return replayed, contextError(ctx)
+		}
+	}
+	return replayed, nil
+}
+
+// replayPass holds the dead letters that failed again during a pass of
+// ReplayDeadLetters, in the order they arrived.
+type replayPass struct {
+	id     string
+	failed map[string]*Message
+	order  []string
+}
+
+// replay publishes the dead letter back onto its topic, acking it once
+// published. It reports whether the message was replayed.
+func (b *Bus) replay(dl *Message, pass string) bool {
+	source := dl.Header(HeaderDeadLetterTopic)
+	if len(source) == 0 {
+		fmt.Printf("Dropped message [%s] on topic [%s]: not a dead letter\n", dl.ID, dl.Topic)
+		settle(dl, Acknowledger.Ack)
+		return false
+	}
+	replays, _ := strconv.Atoi(dl.Header(HeaderDeadLetterReplays))
+
+	msg := NewDefaultMessage(func(m *Message) {
+		m.Topic = source
+		m.ContentType = dl.ContentType
+		m.Headers = dl.copyHeaders()
+		m.Payload.Data = dl.Payload.Data
+	})
+	delete(msg.Headers, HeaderDeadLetterTopic)
+	delete(msg.Headers, HeaderDeadLetterError)
+	delete(msg.Headers, HeaderDeadLetterAttempts)
+	msg.SetHeader(HeaderDeadLetterReplays, strconv.Itoa(replays+1))
+	msg.SetHeader(HeaderDeadLetterPass, pass)
+
+	if err := b.Connection.Publish(msg); err != nil {
+		fmt.Printf("Error replaying message [%s] onto topic [%s]: %s\n", dl.ID, source, err.Error())
+		settle(dl, nak)
+		return false
+	}
+	settle(dl, Acknowledger.Ack)
+	return true
+}
+
+// requeue publishes the dead letters that failed again during the pass back
+// onto the dead-letter topic, acking them once published.
+func (b *Bus) requeue(topic Topic, pass *replayPass) {
+	for _, id := range pass.order {
+		dl := pass.failed[id]
+		msg := NewDefaultMessage(func(m *Message) {
+			m.Topic = topic.String()
+			m.ContentType = dl.ContentType
+			m.Headers = dl.copyHeaders()
+			m.Payload.Data = dl.Payload.Data
+		})
+
+		if err := b.Connection.Publish(msg); err != nil {
+			fmt.Printf("Error requeueing message [%s] onto topic [%s]: %s\n", dl.ID, topic, err.Error())
+			settle(dl, nak)
+			continue
+		}
+		settle(dl, Acknowledger.Ack)
+	}
+}
+
+// nak asks for the message to be redelivered right away.
+func nak(a Acknowledger) error {
+	return a.Nak(0)
+}
+
+// settle acknowledges the message, for providers expecting it.
+func settle(msg *Message, f func(a Acknowledger) error) {
+	if msg.Acker == nil {
+		return
+	}
+	if err := f(msg.Acker); err != nil {
+		fmt.Printf("Error acknowledging message [%s] on topic [%s]: %s\n", msg.ID, msg.Topic, err.Error())
+	}
+}
@@ -0,0 +1,281 @@
+package hub_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jorgeolivero/hub"
+	"github.com/pborman/uuid"
+)
+
+func TestRetry(t *testing.T) {
+	bc := GetBusConnection(t)
+	bus := hub.NewBus(bc, hub.JSON)
+
+	topic := hub.Topic(uuid.New())
+	attempts := int32(0)
+
+	// subscribe, the handler fails twice before succeeding
+	subID, err := bus.Subscribe(topic.Req(), func(c *hub.Context) {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			c.RespondError(errors.New("not yet"))
+			return
+		}
+		c.Respond(Envelope{Foo: "done"})
+	}, hub.WithRetry(hub.ExponentialBackoff(3, time.Millisecond, time.Millisecond*10)))
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+	defer bus.Unsubscribe(subID)
+
+	// request, only the last response is received
+	res := Envelope{}
+	if err := bus.Request(topic, Envelope{}, &res); err != nil {
+		t.Fatalf("Error requesting to bus: %s", err.Error())
+	}
+	if res.Foo != "done" {
+		t.Fatalf("Expected: done Got: %s", res.Foo)
+	}
+	if n := atomic.LoadInt32(&attempts); n != 3 {
+		t.Fatalf("Expected 3 attempts, got %d", n)
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	bc := GetBusConnection(t)
+	bus := hub.NewBus(bc, hub.JSON)
+
+	topic := hub.Topic(uuid.New())
+	deadLetters := hub.Topic(uuid.New())
+
+	// listen to dead letters
+	received := make(chan *hub.Context, 1)
+	dlqID, err := bus.Listen(deadLetters, func(c *hub.Context) {
+		received <- c
+	})
+	if err != nil {
+		t.Fatalf("Error listening: %s", err.Error())
+	}
+	defer bus.Unsubscribe(dlqID)
+
+	// subscribe, the handler always panics
+	subID, err := bus.Subscribe(topic.Req(), func(c *hub.Context) {
+		panic("broken")
+	}, hub.WithRetry(hub.ExponentialBackoff(2, time.Millisecond, time.Millisecond)), hub.WithDeadLetter(deadLetters))
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+	defer bus.Unsubscribe(subID)
+
+	// request, the last error is returned
+	err = bus.Request(topic, Envelope{Foo: "hello"}, &Envelope{}, hub.WithHeader("Trace-Id", "abc"))
+	if !errors.Is(err, hub.ErrHandlerPanic) {
+		t.Fatalf("Expected handler panic error, got: %v", err)
+	}
+
+	select {
+	case c := <-received:
+		if c.Header(hub.HeaderDeadLetterTopic) != topic.Req().String() {
+			t.Fatalf("Unexpected dead letter topic: %s", c.Header(hub.HeaderDeadLetterTopic))
+		}
+		if c.Header(hub.HeaderDeadLetterAttempts) != "2" {
+			t.Fatalf("Expected 2 attempts, got: %s", c.Header(hub.HeaderDeadLetterAttempts))
+		}
+		if len(c.Header(hub.HeaderDeadLetterError)) == 0 {
+			t.Fatalf("Dead letter has no error")
+		}
+		if c.Header("Trace-Id") != "abc" {
+			t.Fatalf("Original headers were not kept")
+		}
+		req := Envelope{}
+		if err := c.Bind(&req); err != nil || req.Foo != "hello" {
+			t.Fatalf("Original payload was not kept")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out")
+	}
+}
+
+// ReplayInBackground replays the dead letters until the topic is quiet,
+// sending the number replayed once done.
+func ReplayInBackground(t *testing.T, bus *hub.Bus, deadLetters hub.Topic) chan int {
+	replayed := make(chan int, 1)
+	go func() {
+		n, err := bus.ReplayDeadLetters(context.Background(), deadLetters, hub.WithReplayQuiescence(time.Millisecond*200))
+		if err != nil {
+			t.Errorf("Error replaying dead letters: %s", err.Error())
+		}
+		replayed <- n
+	}()
+
+	// let the replay subscribe first, dead letters are not kept
+	time.Sleep(time.Millisecond * 20)
+	return replayed
+}
+
+func TestReplayDeadLetters(t *testing.T) {
+	bc := GetBusConnection(t)
+	bus := hub.NewBus(bc, hub.JSON)
+
+	topic := hub.Topic(uuid.New())
+	deadLetters := hub.Topic(uuid.New())
+	replayed := ReplayInBackground(t, bus, deadLetters)
+
+	// subscribe, the handler fails the first time only
+	handled := make(chan *hub.Context, 1)
+	attempts := int32(0)
+	subID, err := bus.Subscribe(topic, func(c *hub.Context) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			panic("broken")
+		}
+		handled <- c
+	}, hub.WithDeadLetter(deadLetters))
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+	defer bus.Unsubscribe(subID)
+
+	// publish
+	if err := bus.Publish(topic, Envelope{}); err != nil {
+		t.Fatalf("Error publishing to bus: %s", err.Error())
+	}
+
+	select {
+	case c := <-handled:
+		if len(c.Header(hub.HeaderDeadLetterTopic)) > 0 {
+			t.Fatalf("Replayed message kept its dead letter headers")
+		}
+		if c.Header(hub.HeaderDeadLetterReplays) != "1" {
+			t.Fatalf("Expected 1 replay, got: %s", c.Header(hub.HeaderDeadLetterReplays))
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out")
+	}
+
+	// the pass ends once the topic is quiet
+	select {
+	case n := <-replayed:
+		if n != 1 {
+			t.Fatalf("Expected 1 message replayed, got: %d", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Replay did not stop")
+	}
+}
+
+func TestReplayDeadLettersOnce(t *testing.T) {
+	bc := GetBusConnection(t)
+	bus := hub.NewBus(bc, hub.JSON)
+
+	topic := hub.Topic(uuid.New())
+	deadLetters := hub.Topic(uuid.New())
+
+	// observe the dead letters
+	failed := make(chan *hub.Context, 8)
+	dlqID, err := bus.Listen(deadLetters, func(c *hub.Context) {
+		failed <- c
+	})
+	if err != nil {
+		t.Fatalf("Error listening: %s", err.Error())
+	}
+	defer bus.Unsubscribe(dlqID)
+	replayed := ReplayInBackground(t, bus, deadLetters)
+
+	// subscribe, the handler always fails
+	attempts := int32(0)
+	subID, err := bus.Subscribe(topic, func(c *hub.Context) {
+		atomic.AddInt32(&attempts, 1)
+		panic("broken")
+	}, hub.WithDeadLetter(deadLetters))
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+	defer bus.Unsubscribe(subID)
+
+	if err := bus.Publish(topic, Envelope{}); err != nil {
+		t.Fatalf("Error publishing to bus: %s", err.Error())
+	}
+
+	select {
+	case n := <-replayed:
+		if n != 1 {
+			t.Fatalf("Expected 1 message replayed, got: %d", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Replay did not stop")
+	}
+
+	// the message failed once, then once more after being replayed, and was
+	// requeued once the pass stopped
+	if n := atomic.LoadInt32(&attempts); n != 2 {
+		t.Fatalf("Expected 2 attempts, got: %d", n)
+	}
+	replays := map[string]int{}
+	var requeued map[string]string
+	for i := 0; i < 3; i++ {
+		select {
+		case c := <-failed:
+			replays[c.Header(hub.HeaderDeadLetterReplays)]++
+			if len(c.Header(hub.HeaderDeadLetterPass)) > 0 {
+				requeued = c.Headers()
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out")
+		}
+	}
+	if replays[""] != 1 || replays["1"] != 2 {
+		t.Fatalf("Expected a dead letter before replaying and two after, got: %v", replays)
+	}
+
+	// a later pass replays it again, the memory provider does not keep it so
+	// publish it once more
+	replayed = ReplayInBackground(t, bus, deadLetters)
+	if err := bus.Publish(deadLetters, Envelope{}, hub.WithHeaders(requeued)); err != nil {
+		t.Fatalf("Error publishing to bus: %s", err.Error())
+	}
+	select {
+	case n := <-replayed:
+		if n != 1 {
+			t.Fatalf("Expected 1 message replayed, got: %d", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Replay did not stop")
+	}
+	if n := atomic.LoadInt32(&attempts); n != 3 {
+		t.Fatalf("Expected 3 attempts, got: %d", n)
+	}
+}
+
+func TestReplayDeadLettersLimit(t *testing.T) {
+	bc := GetBusConnection(t)
+	bus := hub.NewBus(bc, hub.JSON)
+
+	topic := hub.Topic(uuid.New())
+	deadLetters := hub.Topic(uuid.New())
+
+	replayed := make(chan int, 1)
+	go func() {
+		n, _ := bus.ReplayDeadLetters(context.Background(), deadLetters, hub.WithReplayLimit(2))
+		replayed <- n
+	}()
+	time.Sleep(time.Millisecond * 20)
+
+	for i := 0; i < 3; i++ {
+		msg := hub.WithHeader(hub.HeaderDeadLetterTopic, topic.String())
+		if err := bus.Publish(deadLetters, Envelope{}, msg); err != nil {
+			t.Fatalf("Error publishing to bus: %s", err.Error())
+		}
+	}
+
+	select {
+	case n := <-replayed:
+		if n != 2 {
+			t.Fatalf("Expected 2 messages replayed, got: %d", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Replay did not stop")
+	}
+}
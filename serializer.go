@@ -2,6 +2,12 @@ package hub
 
 import (
 	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
 )
 
 type SerializationFormat string
@@ -11,12 +17,35 @@ const (
 	BINARY SerializationFormat = "binary"
 )
 
-func (sf SerializationFormat) GetSerializer() BusSerializer {
+// Content types recorded on messages, telling receivers which serializer
+// decodes their payload.
+const (
+	ContentTypeJSON   = "application/json"
+	ContentTypeBinary = "application/x-hub-binary"
+)
+
+// ContentType returns the content type of payloads serialized in the format.
+func (sf SerializationFormat) ContentType() string {
+	switch sf {
+	case JSON:
+		return ContentTypeJSON
+	case BINARY:
+		return ContentTypeBinary
+	default:
+		return ""
+	}
+}
+
+// GetSerializer returns the serializer of the format, unknown formats are
+// an error.
+func (sf SerializationFormat) GetSerializer() (BusSerializer, error) {
 	switch sf {
 	case JSON:
-		return &JSONSerializer{}
+		return &JSONSerializer{}, nil
+	case BINARY:
+		return &BinarySerializer{}, nil
 	default:
-		return &JSONSerializer{}
+		return nil, fmt.Errorf("Unknown serialization format: %q", string(sf))
 	}
 }
 
@@ -34,3 +63,80 @@ func (s *JSONSerializer) Serialize(obj interface{}) ([]byte, error) {
 func (s *JSONSerializer) Deserialize(data []byte, obj interface{}) error {
 	return json.Unmarshal(data, obj)
 }
+
+// BinarySerializer encodes protobuf messages with protobuf and any other
+// value with msgpack. Both ends must agree on whether a payload is a
+// protobuf message.
+type BinarySerializer struct{}
+
+func (s *BinarySerializer) Serialize(obj interface{}) ([]byte, error) {
+	if m, ok := obj.(proto.Message); ok {
+		return proto.Marshal(m)
+	}
+	return msgpack.Marshal(obj)
+}
+
+// Deserialize decodes into a protobuf message, or a pointer to one as in
+// Call and Handle with a message pointer type, allocating the message when
+// nil. Other values are decoded with msgpack.
+func (s *BinarySerializer) Deserialize(data []byte, obj interface{}) error {
+	if m, ok := obj.(proto.Message); ok {
+		return proto.Unmarshal(data, m)
+	}
+	if m, ok := protoPointee(obj); ok {
+		return proto.Unmarshal(data, m)
+	}
+	return msgpack.Unmarshal(data, obj)
+}
+
+var protoMessageType = reflect.TypeOf((*proto.Message)(nil)).Elem()
+
+// protoPointee returns the protobuf message pointed to by obj, allocating it
+// when nil, if obj is a pointer to a protobuf message pointer.
+func protoPointee(obj interface{}) (proto.Message, bool) {
+	v := reflect.ValueOf(obj)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return nil, false
+	}
+	elem := v.Elem()
+	if elem.Kind() != reflect.Ptr || !elem.Type().Implements(protoMessageType) {
+		return nil, false
+	}
+	if elem.IsNil() {
+		elem.Set(reflect.New(elem.Type().Elem()))
+	}
+	return elem.Interface().(proto.Message), true
+}
+
+// serializerRegistry holds the serializers of a bus keyed by content type.
+type serializerRegistry struct {
+	lock        sync.RWMutex
+	serializers map[string]BusSerializer
+}
+
+func newSerializerRegistry() *serializerRegistry {
+	return &serializerRegistry{
+		serializers: map[string]BusSerializer{
+			ContentTypeJSON:   &JSONSerializer{},
+			ContentTypeBinary: &BinarySerializer{},
+		},
+	}
+}
+
+func (r *serializerRegistry) register(contentType string, s BusSerializer) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.serializers[contentType] = s
+}
+
+func (r *serializerRegistry) get(contentType string) (BusSerializer, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	s, ok := r.serializers[contentType]
+	if !ok {
+		return nil, serializationError(fmt.Errorf("No serializer for content type %q", contentType))
+	}
+	return s, nil
+}
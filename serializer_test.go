@@ -0,0 +1,197 @@
+package hub_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jorgeolivero/hub"
+	"github.com/pborman/uuid"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestGetSerializerUnknownFormat(t *testing.T) {
+	if _, err := hub.SerializationFormat("xml").GetSerializer(); err == nil {
+		t.Fatalf("Expected unknown format to produce an error")
+	}
+}
+
+func TestNewBusUnknownFormat(t *testing.T) {
+	bus, err := hub.NewBusWithFormat(GetBusConnection(t), hub.SerializationFormat("xml"))
+	if err == nil || bus != nil {
+		t.Fatalf("Expected unknown format to produce an error")
+	}
+}
+
+func TestBinarySerializer(t *testing.T) {
+	s, err := hub.BINARY.GetSerializer()
+	if err != nil {
+		t.Fatalf("Error getting serializer: %s", err.Error())
+	}
+	if _, ok := s.(*hub.JSONSerializer); ok {
+		t.Fatalf("BINARY format produced a JSON serializer")
+	}
+
+	// plain values
+	req := Envelope{uuid.New(), uuid.New()}
+	data, err := s.Serialize(req)
+	if err != nil {
+		t.Fatalf("Error serializing struct: %s", err.Error())
+	}
+	var res Envelope
+	if err := s.Deserialize(data, &res); err != nil {
+		t.Fatalf("Error deserializing struct: %s", err.Error())
+	}
+	if res != req {
+		t.Fatalf("Struct did not survive serialization: %#v", res)
+	}
+
+	// empty structs, as used by heartbeats
+	data, err = s.Serialize(struct{}{})
+	if err != nil {
+		t.Fatalf("Error serializing empty struct: %s", err.Error())
+	}
+	if err := s.Deserialize(data, &struct{}{}); err != nil {
+		t.Fatalf("Error deserializing empty struct: %s", err.Error())
+	}
+
+	// protobuf messages
+	data, err = s.Serialize(wrapperspb.String(req.Foo))
+	if err != nil {
+		t.Fatalf("Error serializing protobuf message: %s", err.Error())
+	}
+	var value wrapperspb.StringValue
+	if err := s.Deserialize(data, &value); err != nil {
+		t.Fatalf("Error deserializing protobuf message: %s", err.Error())
+	}
+	if value.GetValue() != req.Foo {
+		t.Fatalf("Protobuf message did not survive serialization: %s", value.GetValue())
+	}
+}
+
+func TestRequestBinary(t *testing.T) {
+	bc := GetBusConnection(t)
+	bus := hub.NewBus(bc, hub.BINARY)
+
+	topic := hub.Topic(uuid.New())
+	req := Envelope{uuid.New(), uuid.New()}
+
+	// subscribe
+	subID, err := bus.Subscribe(topic.Req(), func(c *hub.Context) {
+		var data Envelope
+		if err := c.Bind(&data); err != nil {
+			t.Errorf("error binding request: %s", err.Error())
+		}
+		c.Respond(data)
+	})
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+	defer func(subID string) {
+		bus.Unsubscribe(subID)
+	}(subID)
+
+	// publish
+	var res Envelope
+	if err := bus.Request(topic, req, &res); err != nil {
+		t.Fatalf("Error requesting to bus: %s", err.Error())
+	}
+	if res != req {
+		t.Fatalf("Request failed, incorrect response")
+	}
+}
+
+func TestRequestContentTypes(t *testing.T) {
+	// the responder defaults to JSON, the requester to BINARY
+	responder := hub.NewBus(GetBusConnection(t), hub.JSON)
+	requester := hub.NewBus(GetBusConnection(t), hub.BINARY)
+
+	topic := hub.Topic(uuid.New())
+	req := Envelope{uuid.New(), uuid.New()}
+	contentTypes := make(chan string, 2)
+
+	// subscribe
+	subID, err := responder.Subscribe(topic.Req(), func(c *hub.Context) {
+		contentTypes <- c.ContentType()
+		var data Envelope
+		if err := c.Bind(&data); err != nil {
+			t.Errorf("error binding request: %s", err.Error())
+		}
+		c.Respond(data)
+	})
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+	defer func(subID string) {
+		responder.Unsubscribe(subID)
+	}(subID)
+
+	// the bus default, then a per-call content type
+	opts := [][]hub.MessageOption{nil, {hub.WithContentType(hub.ContentTypeJSON)}}
+	for i, expected := range []string{hub.ContentTypeBinary, hub.ContentTypeJSON} {
+		var res Envelope
+		if err := requester.Request(topic, req, &res, opts[i]...); err != nil {
+			t.Fatalf("Error requesting to bus: %s", err.Error())
+		}
+		if res != req {
+			t.Fatalf("Request failed, incorrect response")
+		}
+		if ct := <-contentTypes; ct != expected {
+			t.Fatalf("Expected content type %s, got: %s", expected, ct)
+		}
+	}
+}
+
+type upperSerializer struct{}
+
+func (s *upperSerializer) Serialize(obj interface{}) ([]byte, error) {
+	return []byte(strings.ToUpper(obj.(string))), nil
+}
+
+func (s *upperSerializer) Deserialize(data []byte, obj interface{}) error {
+	*(obj.(*string)) = string(data)
+	return nil
+}
+
+func TestRegisterSerializer(t *testing.T) {
+	bus := hub.NewBus(GetBusConnection(t), hub.JSON)
+	bus.RegisterSerializer("text/upper", &upperSerializer{})
+
+	topic := hub.Topic(uuid.New())
+	received := make(chan string, 1)
+
+	// subscribe
+	subID, err := bus.Subscribe(topic, func(c *hub.Context) {
+		var data string
+		if err := c.Bind(&data); err != nil {
+			t.Errorf("error binding request: %s", err.Error())
+		}
+		received <- data
+	})
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+	defer func(subID string) {
+		bus.Unsubscribe(subID)
+	}(subID)
+
+	// publish
+	if err := bus.Publish(topic, "hello", hub.WithContentType("text/upper")); err != nil {
+		t.Fatalf("Error publishing to bus: %s", err.Error())
+	}
+	select {
+	case data := <-received:
+		if data != "HELLO" {
+			t.Fatalf("Registered serializer not used: %s", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out")
+	}
+
+	// unknown content types are serialization errors
+	err = bus.Publish(topic, "hello", hub.WithContentType("text/unknown"))
+	if !errors.Is(err, hub.ErrSerialization) {
+		t.Fatalf("Expected serialization error, got: %v", err)
+	}
+}
@@ -5,30 +5,22 @@ import (
 	"time"
 
 	"github.com/jorgeolivero/hub"
-	"github.com/jorgeolivero/hub/provider/nats"
+	"github.com/jorgeolivero/hub/provider/memory"
 	"github.com/jorgeolivero/hub/streamer"
 	"github.com/pborman/uuid"
 )
 
-func GetBus(t *testing.T, opts ...func(c *nats.Config)) *hub.Bus {
-	cfg := &nats.Config{
-		User:           "",
-		Password:       "",
-		Host:           "192.168.99.100",
-		Port:           "4222",
-		Service:        "STREAMER_TEST",
-		DefaultTimeout: time.Second * 5,
+func GetBus(t *testing.T, opts ...func(b *hub.Bus)) *hub.Bus {
+	conn, err := memory.NewConnection(memory.DefaultConfig("STREAMER_TEST"))
+	if err != nil {
+		t.Fatalf("Error creating memory connection: %s", err.Error())
 	}
 
+	bus := hub.NewBus(conn, hub.JSON)
 	for _, f := range opts {
-		f(cfg)
-	}
-
-	conn, err := nats.NewConnection(cfg.ConnectionUrl(), cfg)
-	if err != nil {
-		t.Fatalf("Error creating NATs connection: %s", err.Error())
+		f(bus)
 	}
-	return hub.NewBus(conn, hub.JSON)
+	return bus
 }
 
 func GenerateStreamInfo(opts ...func(si *streamer.StreamInfo)) streamer.StreamInfo {
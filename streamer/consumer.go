@@ -1,7 +1,9 @@
 package streamer
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"sync"
 	"time"
 
@@ -9,114 +11,390 @@ import (
 )
 
 const (
-	RING_BUFFER_LIMIT = 50
+	// Deprecated: use StreamInfo.BufferSize.
+	RING_BUFFER_LIMIT = DefaultBufferSize
 )
 
+// Consumer receives the events of a stream, keeping the producer alive with
+// heartbeats. It holds up to StreamInfo.BufferSize events not yet consumed,
+// what happens beyond that is decided by the overflow policy. Under flow
+// control the producer is kept from sending more.
+//
+// Events sent by a Producer are put back in order, see ConsumerOptions. A
+// consumer closed early may be followed by one resuming the stream from its
+// Offset, when the producer keeps a replay buffer.
+//
+// The consumer closes when Close is called, when its context is done or
+// when a heartbeat fails. Events buffered by then are still returned by
+// Next, which returns io.EOF afterwards.
 type Consumer struct {
 	Bus        *hub.Bus
 	StreamInfo StreamInfo
 
-	closeLock             *sync.Mutex
-	isClosed              bool
-	closeSubscriptionChan chan struct{}
-	closeRingChan         chan struct{}
-	closeHeartbeatsChan   chan struct{}
+	ctx       context.Context
+	cancel    context.CancelFunc
+	subID     string
+	buffer    chan *hub.Context
+	reorderer *reorderer
+	grant     chan struct{}
 
-	stream chan *hub.Context
-	output chan *hub.Context
+	// lock guards the sequence numbers tracked for credits and resuming
+	lock         sync.Mutex
+	released     uint64
+	grantedLimit uint64
+	offset       uint64
+
+	errLock sync.Mutex
+	err     error
+}
+
+// ConsumerOptions configures how a consumer orders and buffers the events it
+// receives.
+type ConsumerOptions struct {
+	// ReorderWindow is the number of events held while waiting for a
+	// missing one, before giving up on it.
+	ReorderWindow int
+
+	// Strict closes the consumer with an error wrapping ErrGap rather than
+	// giving up on missing events or dropping events from a full buffer, so
+	// that Next yields every event in send order. Otherwise events given up
+	// on are still yielded if they arrive later, out of order.
+	Strict bool
+
+	// OnGap is called with the events given up on or dropped from a full
+	// buffer, OnDuplicate with the events received more than once. They are
+	// called from the goroutine receiving events and should not block.
+	OnGap       func(gap Gap)
+	OnDuplicate func(seq uint64)
+
+	// Overflow decides what happens to an event arriving while the buffer
+	// is full, hub.OverflowDropOldest by default and hub.OverflowBlock in
+	// strict mode. hub.OverflowBlock stops receiving until there is room,
+	// hub.OverflowReject closes the consumer with an error wrapping
+	// hub.ErrOverloaded.
+	Overflow hub.OverflowPolicy
+
+	// Resume is the sequence number of the last event consumed from the
+	// stream, by an earlier consumer. The producer sends the events after
+	// it again, provided it still holds them, see StreamInfo.ReplaySize.
+	Resume *uint64
+
+	// announce sends the first heartbeat right away, for the producer to
+	// know the consumer attached
+	announce bool
+}
+
+type ConsumerOption func(o *ConsumerOptions)
+
+// WithReorderWindow sets the number of events held while waiting for a
+// missing one.
+func WithReorderWindow(n int) ConsumerOption {
+	return func(o *ConsumerOptions) {
+		o.ReorderWindow = n
+	}
+}
+
+// WithStrictOrdering makes Next yield every event in send order, closing
+// the consumer when events are missing.
+func WithStrictOrdering() ConsumerOption {
+	return func(o *ConsumerOptions) {
+		o.Strict = true
+	}
 }
 
-func NewConsumer(bus *hub.Bus, info StreamInfo) *Consumer {
+// WithGapHandler calls the handler with the events given up on.
+func WithGapHandler(handler func(gap Gap)) ConsumerOption {
+	return func(o *ConsumerOptions) {
+		o.OnGap = handler
+	}
+}
+
+// WithDuplicateHandler calls the handler with the events received more than
+// once.
+func WithDuplicateHandler(handler func(seq uint64)) ConsumerOption {
+	return func(o *ConsumerOptions) {
+		o.OnDuplicate = handler
+	}
+}
+
+// WithOverflow sets what happens to an event arriving while the buffer is
+// full.
+func WithOverflow(policy hub.OverflowPolicy) ConsumerOption {
+	return func(o *ConsumerOptions) {
+		o.Overflow = policy
+	}
+}
+
+// WithResume resumes the stream after the event with the sequence number,
+// see Consumer.Offset. The consumer closes with an error matching
+// ErrReplayUnavailable when the producer no longer holds the events.
+func WithResume(offset uint64) ConsumerOption {
+	return func(o *ConsumerOptions) {
+		o.Resume = &offset
+	}
+}
+
+// overflowUnset lets the overflow policy default according to the ordering
+// mode.
+const overflowUnset hub.OverflowPolicy = -1
+
+func newConsumerOptions(opts ...ConsumerOption) *ConsumerOptions {
+	o := &ConsumerOptions{
+		ReorderWindow: 16,
+		Overflow:      overflowUnset,
+	}
+	for _, f := range opts {
+		f(o)
+	}
+	if o.Overflow == overflowUnset {
+		o.Overflow = hub.OverflowDropOldest
+		if o.Strict {
+			o.Overflow = hub.OverflowBlock
+		}
+	}
+	return o
+}
+
+// NewConsumer starts consuming the stream. It panics when subscribing to
+// the stream fails.
+func NewConsumer(bus *hub.Bus, info StreamInfo, opts ...ConsumerOption) *Consumer {
+	return NewConsumerContext(context.Background(), bus, info, opts...)
+}
+
+// NewConsumerContext behaves like NewConsumer, but the consumer closes once
+// the context is done.
+func NewConsumerContext(ctx context.Context, bus *hub.Bus, info StreamInfo, opts ...ConsumerOption) *Consumer {
+	c, err := newConsumer(ctx, bus, info, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func newConsumer(ctx context.Context, bus *hub.Bus, info StreamInfo, opts ...ConsumerOption) (*Consumer, error) {
+	o := newConsumerOptions(opts...)
+	first := uint64(1)
+	if o.Resume != nil {
+		first = *o.Resume + 1
+	}
 	c := &Consumer{
-		Bus:                   bus,
-		StreamInfo:            info,
-		closeLock:             &sync.Mutex{},
-		isClosed:              false,
-		closeSubscriptionChan: make(chan struct{}, 1),
-		closeRingChan:         make(chan struct{}, 1),
-		closeHeartbeatsChan:   make(chan struct{}, 1),
-		stream:                make(chan *hub.Context),
-		output:                make(chan *hub.Context),
-	}
-
-	go c.startRing()
-	go c.subscribe()
+		Bus:          bus,
+		StreamInfo:   info,
+		buffer:       make(chan *hub.Context, info.bufferSize()),
+		reorderer:    newReorderer(o, first),
+		grant:        make(chan struct{}, 1),
+		released:     first - 1,
+		grantedLimit: first - 1 + uint64(info.bufferSize()),
+		offset:       first - 1,
+	}
+	c.ctx, c.cancel = context.WithCancel(ctx)
+
+	// the subscription goes away along with the context
+	var err error
+	c.subID, err = bus.ListenContext(c.ctx, info.StreamTopic, c.receive, hub.WithOrderedDelivery())
+	if err != nil {
+		c.cancel()
+		return nil, fmt.Errorf("Subscribing to stream [%s] failed with error: %w", info.StreamTopic, err)
+	}
+
 	go c.handleHeartbeats()
 
-	return c
+	return c, nil
 }
 
-func (c *Consumer) startRing() {
-	buffer := make(chan *hub.Context, RING_BUFFER_LIMIT)
-	go func() {
-		for {
-			c.output <- <-buffer
+// receive puts the event back in order, then buffers the events ready to be
+// consumed. Events without a sequence number are buffered as they come.
+func (c *Consumer) receive(cc *hub.Context) {
+	seq, ok := Sequence(cc)
+	if !ok {
+		c.push(cc)
+		return
+	}
+
+	ready, err := c.reorderer.add(seq, cc)
+	for _, e := range ready {
+		if isEnd(e) {
+			c.Close()
+			return
 		}
-	}()
-	for {
+		c.push(e)
+	}
+	if err != nil {
+		fmt.Printf("Stream [%s] closed: %s\n", c.StreamInfo.StreamTopic, err.Error())
+		c.closeWithError(err)
+		return
+	}
+	c.release(c.reorderer.next - 1)
+}
+
+// push buffers the event, applying the overflow policy when the buffer is
+// full.
+func (c *Consumer) push(cc *hub.Context) {
+	select {
+	case c.buffer <- cc:
+		return
+	default:
+	}
+
+	switch c.reorderer.opts.Overflow {
+	case hub.OverflowBlock:
 		select {
-		case buffer <- <-c.stream:
-			if len(buffer) == cap(buffer) {
-				// Potential deadlock here
-				<-buffer
+		case c.buffer <- cc:
+		case <-c.ctx.Done():
+		}
+	case hub.OverflowDropNewest:
+		c.drop(cc)
+	case hub.OverflowReject:
+		err := fmt.Errorf("%w: stream buffer full", hub.ErrOverloaded)
+		fmt.Printf("Stream [%s] closed: %s\n", c.StreamInfo.StreamTopic, err.Error())
+		c.closeWithError(err)
+	default:
+		// strict consumers keep the events buffered, closing instead
+		if c.reorderer.opts.Strict {
+			c.drop(cc)
+			return
+		}
+		for c.ctx.Err() == nil {
+			select {
+			case c.buffer <- cc:
+				return
+			default:
+			}
+
+			select {
+			case dropped := <-c.buffer:
+				c.drop(dropped)
+			default:
 			}
-		case <-c.closeRingChan:
-			break
 		}
 	}
-	fmt.Printf("Stream ring for topic [%s] closed\n", c.StreamInfo.StreamTopic)
 }
 
-func (c *Consumer) subscribe() {
-	subID, err := c.Bus.Listen(c.StreamInfo.StreamTopic, func(cc *hub.Context) {
-		c.stream <- cc
-	})
-	if err != nil {
-		panic(fmt.Errorf("Subscribing to stream [%s] failed with error: %s", c.StreamInfo.StreamTopic, err.Error()))
+// drop reports an event dropped from the buffer as a gap. Strict consumers
+// close with an error wrapping ErrGap instead.
+func (c *Consumer) drop(cc *hub.Context) {
+	seq, ok := Sequence(cc)
+	if c.reorderer.opts.Strict {
+		err := fmt.Errorf("%w: stream buffer full, event %d dropped", ErrGap, seq)
+		fmt.Printf("Stream [%s] closed: %s\n", c.StreamInfo.StreamTopic, err.Error())
+		c.closeWithError(err)
+		return
 	}
 
-	<-c.closeSubscriptionChan
-
-	c.Bus.Unsubscribe(subID)
-	fmt.Printf("Stream subscription for topic [%s] closed\n", c.StreamInfo.StreamTopic)
+	fmt.Printf("Stream buffer for topic [%s] full, dropped event [%s]\n", c.StreamInfo.StreamTopic, cc.MessageID())
+	if ok && c.reorderer.opts.OnGap != nil {
+		c.reorderer.opts.OnGap(Gap{From: seq, To: seq})
+	}
 }
 
 func (c *Consumer) handleHeartbeats() {
+	ticker := time.NewTicker(c.StreamInfo.HeartbeatInterval / 3)
+	defer ticker.Stop()
+
+	// consumers opening or resuming a stream announce themselves right
+	// away, resuming ones asking for the events they missed
+	if o := c.reorderer.opts; o.announce || o.Resume != nil {
+		if !c.heartbeat(o.Resume) {
+			return
+		}
+	}
+
 	for {
 		select {
-		case <-time.After((c.StreamInfo.HeartbeatInterval / 3)):
-			err := c.Bus.Request(c.StreamInfo.HeartbeatTopic, struct{}{}, &struct{}{})
-			if err != nil {
-				fmt.Printf("Heartbeat request failed for stream [%s] with error: %s\n", c.StreamInfo.StreamTopic, err.Error())
-				c.Close()
-			}
-		case <-c.closeHeartbeatsChan:
+		case <-ticker.C:
+		case <-c.grant:
+		case <-c.ctx.Done():
+			return
+		}
+
+		if !c.heartbeat(nil) {
 			return
 		}
 	}
 }
 
-func (c *Consumer) Next() *hub.Context {
-	return <-c.output
+// heartbeat keeps the producer alive, closing the consumer when it fails.
+// Heartbeats grant credits under flow control, and pace replays.
+func (c *Consumer) heartbeat(resume *uint64) bool {
+	hb := heartbeat{Resume: resume, Limit: c.limit()}
+	err := c.Bus.RequestContext(c.ctx, c.StreamInfo.HeartbeatTopic, hb, &struct{}{})
+	if err != nil {
+		if c.ctx.Err() == nil {
+			fmt.Printf("Heartbeat request failed for stream [%s] with error: %s\n", c.StreamInfo.StreamTopic, err.Error())
+			c.closeWithError(err)
+		}
+		return false
+	}
+	c.granted(hb.Limit)
+	return true
+}
+
+// Next returns the next event of the stream, waiting for one until the
+// context is done. Once the consumer is closed and its buffered events are
+// consumed, it returns io.EOF.
+func (c *Consumer) Next(ctx context.Context) (*hub.Context, error) {
+	cc, err := c.next(ctx)
+	if err == nil {
+		c.consumedSequence(cc)
+		c.consumed()
+	}
+	return cc, err
+}
+
+func (c *Consumer) next(ctx context.Context) (*hub.Context, error) {
+	// buffered events come first, even after closing
+	select {
+	case cc := <-c.buffer:
+		return cc, nil
+	default:
+	}
+
+	select {
+	case cc := <-c.buffer:
+		return cc, nil
+	case <-c.ctx.Done():
+		select {
+		case cc := <-c.buffer:
+			return cc, nil
+		default:
+			return nil, io.EOF
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Done returns a channel closed once the consumer is closed.
+func (c *Consumer) Done() <-chan struct{} {
+	return c.ctx.Done()
+}
+
+// Err returns the error that closed the consumer, such as a failed
+// heartbeat, or nil.
+func (c *Consumer) Err() error {
+	c.errLock.Lock()
+	defer c.errLock.Unlock()
+
+	return c.err
 }
 
 func (c *Consumer) IsOpen() bool {
-	return !c.isClosed
+	return c.ctx.Err() == nil
 }
 
+// Close stops consuming the stream. It may be called more than once.
 func (c *Consumer) Close() {
-	c.closeLock.Lock()
-	defer c.closeLock.Unlock()
+	c.closeWithError(nil)
+}
 
-	if c.isClosed {
-		fmt.Printf("Attempt to close closed stream consumer for topic [%s] short circuited\n", c.StreamInfo.StreamTopic)
-		return
+func (c *Consumer) closeWithError(err error) {
+	// cancelling under the lock keeps the first error from being overwritten
+	c.errLock.Lock()
+	if c.ctx.Err() == nil {
+		c.err = err
 	}
-	c.isClosed = true
-
-	c.closeRingChan <- struct{}{}
-	c.closeSubscriptionChan <- struct{}{}
-	c.closeHeartbeatsChan <- struct{}{}
+	c.cancel()
+	c.errLock.Unlock()
 
-	return
+	c.Bus.Unsubscribe(c.subID)
 }
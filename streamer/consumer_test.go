@@ -1,12 +1,13 @@
 package streamer_test
 
 import (
-	"sync"
+	"context"
+	"io"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/jorgeolivero/hub"
-	"github.com/jorgeolivero/hub/provider/nats"
 	"github.com/jorgeolivero/hub/streamer"
 )
 
@@ -17,27 +18,28 @@ func TestNewConsumerHeartbeats(t *testing.T) {
 	})
 
 	numHeartbeats := 3
-	wg := &sync.WaitGroup{}
-	wg.Add(numHeartbeats)
+	heartbeats := make(chan struct{}, numHeartbeats)
 
-	counter := 0
+	counter := int32(0)
 	bus.Subscribe(si.HeartbeatTopic.Req(), func(c *hub.Context) {
 		c.Respond(struct{}{})
 
-		counter++
-		if counter <= numHeartbeats {
-			wg.Done()
+		if atomic.AddInt32(&counter, 1) <= int32(numHeartbeats) {
+			heartbeats <- struct{}{}
 		}
 	})
 
-	streamer.NewConsumer(bus, si)
+	consumer := streamer.NewConsumer(bus, si)
+	defer consumer.Close()
 
-	wg.Wait()
+	for i := 0; i < numHeartbeats; i++ {
+		<-heartbeats
+	}
 }
 
 func TestConsumerHeartbeatFailure(t *testing.T) {
-	bus := GetBus(t, func(c *nats.Config) {
-		c.DefaultTimeout = time.Millisecond * 500
+	bus := GetBus(t, func(b *hub.Bus) {
+		b.DefaultTimeout = time.Millisecond * 500
 	})
 	si := GenerateStreamInfo(func(si *streamer.StreamInfo) {
 		si.HeartbeatInterval = time.Millisecond * 100
@@ -45,11 +47,20 @@ func TestConsumerHeartbeatFailure(t *testing.T) {
 
 	consumer := streamer.NewConsumer(bus, si)
 
-	<-time.After(bus.DefaultTimeout + si.HeartbeatInterval)
+	select {
+	case <-consumer.Done():
+	case <-time.After(bus.DefaultTimeout + si.HeartbeatInterval):
+	}
 
 	if consumer.IsOpen() {
 		t.Fatalf("Consumer should be closed")
 	}
+	if consumer.Err() == nil {
+		t.Fatalf("Consumer should report the heartbeat failure")
+	}
+	if _, err := consumer.Next(context.Background()); err != io.EOF {
+		t.Fatalf("Expected end of stream, got: %v", err)
+	}
 }
 
 func TestConsumerStream(t *testing.T) {
@@ -66,19 +77,46 @@ func TestConsumerStream(t *testing.T) {
 
 	// Start consumer
 	consumer := streamer.NewConsumer(bus, si)
+	defer consumer.Close()
 
 	// Publish
 	num := 5
 	go func() {
 		for i := 0; i < num; i++ {
 			<-time.After(time.Millisecond * 40)
-			bus.Publish(si.StreamTopic, struct{}{})
+			bus.Publish(si.StreamTopic, i)
 		}
 	}()
 
 	// Recieve
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
 	for i := 0; i < num; i++ {
-		consumer.Next()
+		c, err := consumer.Next(ctx)
+		if err != nil {
+			t.Fatalf("Error receiving event: %s", err.Error())
+		}
+		var count int
+		if err := c.Bind(&count); err != nil {
+			t.Fatalf("Binding stream count failed with error: %s", err.Error())
+		}
+		if count != i {
+			t.Fatalf("Expected: %d Got: %d", i, count)
+		}
+	}
+}
+
+func TestConsumerNextContext(t *testing.T) {
+	bus := GetBus(t)
+	si := GenerateStreamInfo()
+
+	consumer := streamer.NewConsumer(bus, si)
+	defer consumer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	if _, err := consumer.Next(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Expected deadline exceeded, got: %v", err)
 	}
 }
 
@@ -89,48 +127,68 @@ func TestConsumerClose(t *testing.T) {
 		si.HeartbeatInterval = time.Millisecond * 50
 	})
 
-	heartbeats := make(chan struct{})
-	events := make(chan struct{})
-
+	heartbeats := int32(0)
 	bus.Subscribe(si.HeartbeatTopic.Req(), func(c *hub.Context) {
 		c.Respond(struct{}{})
-		heartbeats <- struct{}{}
+		atomic.AddInt32(&heartbeats, 1)
 	})
 
-	// Start consumer
+	// Start consumer, receive an event then close
 	consumer := streamer.NewConsumer(bus, si)
-
+	if err := bus.Publish(si.StreamTopic, 1); err != nil {
+		t.Fatalf("Error publishing: %s", err.Error())
+	}
+	time.Sleep(time.Millisecond * 20)
+	consumer.Close()
 	consumer.Close()
 
-	// Publish
-	go func() {
-		for {
-			<-time.After(time.Millisecond * 10)
-			bus.Publish(si.StreamTopic, struct{}{})
-		}
-	}()
+	select {
+	case <-consumer.Done():
+	default:
+		t.Fatalf("Consumer should be done once closed")
+	}
 
-	// Consumer
-	go func() {
-		for {
-			consumer.Next()
-			events <- struct{}{}
-		}
-	}()
+	// events published after closing are not received
+	if err := bus.Publish(si.StreamTopic, 2); err != nil {
+		t.Fatalf("Error publishing: %s", err.Error())
+	}
 
-	correctBehavior := 0
-	for i := 0; i < 10; i++ {
-		select {
-		case <-events:
-			continue
-		case <-heartbeats:
-			continue
-		case <-time.After(time.Millisecond * 20):
-			correctBehavior++
-		}
+	// the buffered event comes first, then the end of stream
+	c, err := consumer.Next(context.Background())
+	if err != nil {
+		t.Fatalf("Expected the buffered event, got: %s", err.Error())
 	}
+	var count int
+	if err := c.Bind(&count); err != nil || count != 1 {
+		t.Fatalf("Expected the buffered event, got: %d", count)
+	}
+	if _, err := consumer.Next(context.Background()); err != io.EOF {
+		t.Fatalf("Expected end of stream, got: %v", err)
+	}
+
+	// heartbeats stopped, once those sent before closing were answered
+	time.Sleep(si.HeartbeatInterval / 3)
+	sent := atomic.LoadInt32(&heartbeats)
+	time.Sleep(si.HeartbeatInterval)
+	if atomic.LoadInt32(&heartbeats) != sent {
+		t.Fatalf("Consumer kept sending heartbeats after closing")
+	}
+}
 
-	if correctBehavior <= 8 {
-		t.Fatalf("Closing consumer did not produce the correct behavior")
+func TestConsumerContext(t *testing.T) {
+	bus := GetBus(t)
+	si := GenerateStreamInfo()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	consumer := streamer.NewConsumerContext(ctx, bus, si)
+	cancel()
+
+	select {
+	case <-consumer.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("Consumer should close along with its context")
+	}
+	if _, err := consumer.Next(context.Background()); err != io.EOF {
+		t.Fatalf("Expected end of stream, got: %v", err)
 	}
 }
@@ -0,0 +1,99 @@
+package streamer
+
+import (
+	"errors"
+)
+
+var (
+	// ErrBackpressure is returned by TrySend when the consumer granted no
+	// credits for more events.
+	ErrBackpressure = errors.New("Stream backpressure")
+
+	// ErrClosed is returned by sends waiting for credits once the producer
+	// closes.
+	ErrClosed = errors.New("Stream closed")
+)
+
+// Credits returns the number of events the producer may send before
+// waiting for the consumer, under flow control.
+func (p *Producer) Credits() uint64 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.limit <= p.sequence {
+		return 0
+	}
+	return p.limit - p.sequence
+}
+
+// grant raises the sequence number the producer may send up to, waking up
+// sends waiting for credits. Lower limits, from heartbeats arriving late,
+// are ignored.
+func (p *Producer) grant(limit uint64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if limit <= p.limit {
+		return
+	}
+	p.limit = limit
+	select {
+	case p.granted <- struct{}{}:
+	default:
+	}
+}
+
+// limit returns the sequence number the producer may send up to: the
+// events released in order, less those not consumed yet, plus the room in
+// the buffer.
+func (c *Consumer) limit() uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	limit := int64(c.released) - int64(len(c.buffer)) + int64(c.StreamInfo.bufferSize())
+	if limit < 0 {
+		return 0
+	}
+	return uint64(limit)
+}
+
+// release records the events released in order, up to the sequence number.
+func (c *Consumer) release(seq uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if seq > c.released {
+		c.released = seq
+	}
+}
+
+// granted records the limit granted to the producer.
+func (c *Consumer) granted(limit uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if limit > c.grantedLimit {
+		c.grantedLimit = limit
+	}
+}
+
+// consumed grants credits early, without waiting for the next heartbeat,
+// once half of the buffer is free again. Resuming consumers do so to pace
+// the replay, with or without flow control.
+func (c *Consumer) consumed() {
+	if !c.StreamInfo.FlowControl && c.reorderer.opts.Resume == nil {
+		return
+	}
+
+	limit := c.limit()
+	c.lock.Lock()
+	due := limit >= c.grantedLimit+uint64(c.StreamInfo.bufferSize()+1)/2
+	c.lock.Unlock()
+
+	if due {
+		select {
+		case c.grant <- struct{}{}:
+		default:
+		}
+	}
+}
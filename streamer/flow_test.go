@@ -0,0 +1,160 @@
+package streamer_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/jorgeolivero/hub"
+	"github.com/jorgeolivero/hub/streamer"
+)
+
+func TestFlowControl(t *testing.T) {
+	bus := GetBus(t)
+	producer := streamer.NewProducer(bus, hub.Topic("flow"), streamer.WithBufferSize(4), streamer.WithFlowControl())
+	defer producer.Close()
+	consumer := streamer.NewConsumer(bus, producer.StreamInfo)
+	defer consumer.Close()
+
+	for i := 0; i < 4; i++ {
+		if err := producer.TrySend(i); err != nil {
+			t.Fatalf("Error sending: %s", err.Error())
+		}
+	}
+	if err := producer.TrySend(4); !errors.Is(err, streamer.ErrBackpressure) {
+		t.Fatalf("Expected backpressure, got: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	if err := producer.SendContext(ctx, 4); err != context.DeadlineExceeded {
+		t.Fatalf("Expected deadline exceeded, got: %v", err)
+	}
+
+	// consuming half of the buffer grants credits before the next heartbeat
+	sent := make(chan error, 1)
+	go func() {
+		sent <- producer.Send(4)
+	}()
+	ExpectSequence(t, consumer, 1, 2)
+	select {
+	case err := <-sent:
+		if err != nil {
+			t.Fatalf("Error sending: %s", err.Error())
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Send still waiting for credits")
+	}
+	ExpectSequence(t, consumer, 3, 4, 5)
+}
+
+func TestFlowControlProducerClose(t *testing.T) {
+	bus := GetBus(t)
+	producer := streamer.NewProducer(bus, hub.Topic("flow"), streamer.WithBufferSize(1), streamer.WithFlowControl())
+
+	if err := producer.Send(0); err != nil {
+		t.Fatalf("Error sending: %s", err.Error())
+	}
+
+	sent := make(chan error, 1)
+	go func() {
+		sent <- producer.Send(1)
+	}()
+	producer.Close()
+
+	select {
+	case err := <-sent:
+		if err != streamer.ErrClosed {
+			t.Fatalf("Expected closed stream, got: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Send still waiting for credits")
+	}
+}
+
+func TestConsumerOverflowReject(t *testing.T) {
+	bus := GetBus(t)
+	si := GenerateStreamInfo(streamer.WithBufferSize(2))
+	consumer := streamer.NewConsumer(bus, si, streamer.WithOverflow(hub.OverflowReject))
+
+	PublishSequence(t, bus, si, 1, 2, 3)
+
+	select {
+	case <-consumer.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("Consumer should be closed")
+	}
+	if !errors.Is(consumer.Err(), hub.ErrOverloaded) {
+		t.Fatalf("Expected overloaded error, got: %v", consumer.Err())
+	}
+
+	ExpectSequence(t, consumer, 1, 2)
+	if _, err := consumer.Next(context.Background()); err != io.EOF {
+		t.Fatalf("Expected end of stream, got: %v", err)
+	}
+}
+
+func TestConsumerOverflowGaps(t *testing.T) {
+	bus := GetBus(t)
+	si := GenerateStreamInfo(streamer.WithBufferSize(5))
+
+	dropped := make(chan streamer.Gap, 20)
+	consumer := streamer.NewConsumer(bus, si, streamer.WithGapHandler(func(gap streamer.Gap) {
+		dropped <- gap
+	}))
+	defer consumer.Close()
+
+	seqs := []uint64{}
+	for seq := uint64(1); seq <= 20; seq++ {
+		seqs = append(seqs, seq)
+	}
+	PublishSequence(t, bus, si, seqs...)
+
+	// the oldest events are dropped and reported
+	for seq := uint64(1); seq <= 15; seq++ {
+		select {
+		case gap := <-dropped:
+			if gap != (streamer.Gap{From: seq, To: seq}) {
+				t.Fatalf("Unexpected gap: %#v", gap)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Dropped event %d not reported", seq)
+		}
+	}
+	ExpectSequence(t, consumer, 16, 17, 18, 19, 20)
+}
+
+func TestConsumerOverflowStrict(t *testing.T) {
+	bus := GetBus(t)
+	si := GenerateStreamInfo(streamer.WithBufferSize(5))
+
+	// strict consumers block rather than drop events
+	consumer := streamer.NewConsumer(bus, si, streamer.WithStrictOrdering())
+	defer consumer.Close()
+
+	seqs := []uint64{}
+	for seq := uint64(1); seq <= 20; seq++ {
+		seqs = append(seqs, seq)
+	}
+	PublishSequence(t, bus, si, seqs...)
+	time.Sleep(time.Millisecond * 50)
+	ExpectSequence(t, consumer, seqs...)
+	if consumer.Err() != nil {
+		t.Fatalf("Unexpected error: %s", consumer.Err().Error())
+	}
+
+	// unless asked to, they close with a gap error then
+	dropping := streamer.NewConsumer(bus, si, streamer.WithStrictOrdering(), streamer.WithOverflow(hub.OverflowDropOldest))
+	PublishSequence(t, bus, si, seqs...)
+
+	select {
+	case <-dropping.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("Consumer should be closed")
+	}
+	if !errors.Is(dropping.Err(), streamer.ErrGap) {
+		t.Fatalf("Expected gap error, got: %v", dropping.Err())
+	}
+	ExpectSequence(t, dropping, 1, 2, 3, 4, 5)
+}
@@ -0,0 +1,154 @@
+package streamer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/jorgeolivero/hub"
+)
+
+// CodeRejected is the code of ErrRejected.
+const CodeRejected = "STREAM_REJECTED"
+
+// ErrRejected is returned by Open when the handler rejected the stream with
+// Reject. It is a remote error, matching the errors received from handlers
+// with errors.Is.
+var ErrRejected = hub.NewRemoteError(CodeRejected, "Stream rejected")
+
+// Reject returns an error for handlers to reject a stream with, Open
+// returns it matching ErrRejected.
+func Reject(reason string) error {
+	return hub.NewRemoteError(CodeRejected, reason)
+}
+
+// Open requests a stream from the handler of the topic, see Handle, and
+// consumes it. The error of a handler rejecting the stream is returned as
+// is.
+func Open(bus *hub.Bus, topic hub.Topic, req interface{}, opts ...ConsumerOption) (*Consumer, error) {
+	return OpenContext(context.Background(), bus, topic, req, opts...)
+}
+
+// OpenContext behaves like Open, the context bounding the request only.
+func OpenContext(ctx context.Context, bus *hub.Bus, topic hub.Topic, req interface{}, opts ...ConsumerOption) (*Consumer, error) {
+	var si StreamInfo
+	if err := bus.RequestContext(ctx, topic, req, &si); err != nil {
+		return nil, err
+	}
+	return newConsumer(context.Background(), bus, si, append(opts, func(o *ConsumerOptions) {
+		o.announce = true
+	})...)
+}
+
+// Handle serves the streams requested on the topic with Open. For each
+// request it creates a producer, with the options, and invokes the handler
+// with it.
+//
+// The stream is accepted with Accept, or with the first event sent, sends
+// waiting for the consumer to attach. Handlers doing slow work before their
+// first event accept first, so Open does not time out meanwhile. Returning
+// an error before accepting rejects the stream, Open returns the error, see
+// Reject. Once the handler returns
+// without error the end of the stream is sent, otherwise the producer is
+// closed.
+func Handle[Req any](bus *hub.Bus, topic hub.Topic, handler func(req Req, p *Producer) error, opts ...func(si *StreamInfo)) (string, error) {
+	return bus.Subscribe(topic.Req(), func(c *hub.Context) {
+		var req Req
+		if err := c.Bind(&req); err != nil {
+			c.RespondError(err)
+			return
+		}
+
+		p, err := newProducer(bus, topic, opts...)
+		if err != nil {
+			c.RespondError(err)
+			return
+		}
+		p.handshake = &handshake{c: c}
+
+		if err := handler(req, p); err != nil {
+			if !p.reject(err) {
+				fmt.Printf("Stream [%s] failed: %s\n", p.StreamInfo.StreamTopic, err.Error())
+			}
+			p.Close()
+			return
+		}
+		if err := p.End(); err != nil {
+			fmt.Printf("Ending stream [%s] failed: %s\n", p.StreamInfo.StreamTopic, err.Error())
+			p.Close()
+		}
+	})
+}
+
+// handshake replies once to the request of a stream, accepting or rejecting
+// it.
+type handshake struct {
+	c    *hub.Context
+	once sync.Once
+	err  error
+}
+
+// Accept accepts the stream, if it was requested with Open, replying with
+// the stream info without waiting for the first event. It does not wait for
+// the consumer to attach either, sends do. It returns ErrClosed once the
+// stream was rejected.
+func (p *Producer) Accept() error {
+	if p.handshake == nil {
+		return nil
+	}
+
+	h := p.handshake
+	h.once.Do(func() {
+		h.err = h.c.Respond(p.StreamInfo)
+	})
+	return h.err
+}
+
+// tryAccept accepts the stream, if it was requested with Open, returning
+// ErrBackpressure until the consumer attaches.
+func (p *Producer) tryAccept() error {
+	if p.handshake == nil {
+		return nil
+	}
+	if err := p.Accept(); err != nil {
+		return err
+	}
+
+	select {
+	case <-p.attached:
+		return nil
+	default:
+		return ErrBackpressure
+	}
+}
+
+// accept accepts the stream, if it was requested with Open, waiting for the
+// consumer to attach.
+func (p *Producer) accept(ctx context.Context) error {
+	if err := p.tryAccept(); err != ErrBackpressure {
+		return err
+	}
+
+	select {
+	case <-p.attached:
+		return nil
+	case <-p.ctx.Done():
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reject rejects the stream with the error, unless it was accepted already.
+func (p *Producer) reject(err error) bool {
+	rejected := false
+	h := p.handshake
+	h.once.Do(func() {
+		rejected = true
+		h.err = ErrClosed
+		if err := h.c.RespondError(err); err != nil {
+			fmt.Printf("Error rejecting stream [%s]: %s\n", p.StreamInfo.StreamTopic, err.Error())
+		}
+	})
+	return rejected
+}
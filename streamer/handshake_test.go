@@ -0,0 +1,123 @@
+package streamer_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/jorgeolivero/hub"
+	"github.com/jorgeolivero/hub/streamer"
+	"github.com/pborman/uuid"
+)
+
+type ExportRequest struct {
+	Count int `json:"count"`
+}
+
+func HandleExports(t *testing.T, bus *hub.Bus, topic hub.Topic) {
+	_, err := streamer.Handle(bus, topic, func(req ExportRequest, p *streamer.Producer) error {
+		if req.Count < 0 {
+			return streamer.Reject("negative count")
+		}
+		for i := 0; i < req.Count; i++ {
+			if err := p.Send(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error handling streams: %s", err.Error())
+	}
+}
+
+func TestOpen(t *testing.T) {
+	bus := GetBus(t)
+	topic := hub.Topic(uuid.New())
+	HandleExports(t, bus, topic)
+
+	consumer, err := streamer.Open(bus, topic, ExportRequest{Count: 5})
+	if err != nil {
+		t.Fatalf("Error opening stream: %s", err.Error())
+	}
+	defer consumer.Close()
+
+	// every event is received, then the end of the stream
+	ExpectSequence(t, consumer, 1, 2, 3, 4, 5)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := consumer.Next(ctx); err != io.EOF {
+		t.Fatalf("Expected end of stream, got: %v", err)
+	}
+	if consumer.Err() != nil {
+		t.Fatalf("Unexpected error: %s", consumer.Err().Error())
+	}
+}
+
+func TestOpenEmpty(t *testing.T) {
+	bus := GetBus(t)
+	topic := hub.Topic(uuid.New())
+	HandleExports(t, bus, topic)
+
+	consumer, err := streamer.Open(bus, topic, ExportRequest{})
+	if err != nil {
+		t.Fatalf("Error opening stream: %s", err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := consumer.Next(ctx); err != io.EOF {
+		t.Fatalf("Expected end of stream, got: %v", err)
+	}
+}
+
+func TestOpenRejected(t *testing.T) {
+	bus := GetBus(t)
+	topic := hub.Topic(uuid.New())
+	HandleExports(t, bus, topic)
+
+	consumer, err := streamer.Open(bus, topic, ExportRequest{Count: -1})
+	if !errors.Is(err, streamer.ErrRejected) {
+		t.Fatalf("Expected rejection, got: %v", err)
+	}
+	if consumer != nil {
+		t.Fatalf("No consumer expected")
+	}
+	if err.Error() != "negative count" {
+		t.Fatalf("Expected: negative count Got: %s", err.Error())
+	}
+}
+
+func TestOpenAccepted(t *testing.T) {
+	bus := GetBus(t, func(b *hub.Bus) {
+		b.DefaultTimeout = time.Millisecond * 100
+	})
+	topic := hub.Topic(uuid.New())
+
+	// the first event is sent after the request timed out
+	_, err := streamer.Handle(bus, topic, func(req ExportRequest, p *streamer.Producer) error {
+		if err := p.Accept(); err != nil {
+			return err
+		}
+		time.Sleep(bus.DefaultTimeout * 2)
+		return p.Send(0)
+	})
+	if err != nil {
+		t.Fatalf("Error handling streams: %s", err.Error())
+	}
+
+	consumer, err := streamer.Open(bus, topic, ExportRequest{})
+	if err != nil {
+		t.Fatalf("Error opening stream: %s", err.Error())
+	}
+	defer consumer.Close()
+
+	ExpectSequence(t, consumer, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := consumer.Next(ctx); err != io.EOF {
+		t.Fatalf("Expected end of stream, got: %v", err)
+	}
+}
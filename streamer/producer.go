@@ -1,21 +1,60 @@
 package streamer
 
 import (
+	"context"
 	"fmt"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/jorgeolivero/hub"
 )
 
+// Producer sends the events of a stream, for as long as its consumer keeps
+// sending heartbeats.
+//
+// Under flow control, it sends no more events than the consumer granted
+// credits for. The consumer has room for StreamInfo.BufferSize events at
+// first.
 type Producer struct {
 	Bus        *hub.Bus
 	StreamInfo StreamInfo
 
-	isClosed            bool
-	closeHeartbeatsChan chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	subID  string
+
+	sendLock sync.Mutex
+
+	// lock guards the sequence number, the credits and the replay buffer,
+	// replayLock keeps replayed events in order
+	lock       sync.Mutex
+	sequence   uint64
+	limit      uint64
+	granted    chan struct{}
+	replay     []interface{}
+	replayNext uint64
+	replayEnd  uint64
+	replayLock sync.Mutex
+
+	// handshake replies to the request of streams served by Handle, the
+	// first heartbeat closes attached
+	handshake  *handshake
+	attached   chan struct{}
+	attachOnce sync.Once
 }
 
+// NewProducer creates a stream on the topic. It panics when subscribing to
+// heartbeats fails.
 func NewProducer(bus *hub.Bus, topic hub.Topic, opts ...func(si *StreamInfo)) *Producer {
+	p, err := newProducer(bus, topic, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func newProducer(bus *hub.Bus, topic hub.Topic, opts ...func(si *StreamInfo)) (*Producer, error) {
 	// Generate stream topic and heartbeat topic
 	si := NewStreamInfo(topic)
 	for _, f := range opts {
@@ -23,59 +62,211 @@ func NewProducer(bus *hub.Bus, topic hub.Topic, opts ...func(si *StreamInfo)) *P
 	}
 
 	p := &Producer{
-		Bus:                 bus,
-		StreamInfo:          si,
-		isClosed:            false,
-		closeHeartbeatsChan: make(chan struct{}),
+		Bus:        bus,
+		StreamInfo: si,
+		granted:    make(chan struct{}, 1),
+		replay:     make([]interface{}, si.ReplaySize),
+		replayNext: 1,
+		attached:   make(chan struct{}),
 	}
+	if si.FlowControl {
+		p.limit = uint64(si.bufferSize())
+	}
+	p.ctx, p.cancel = context.WithCancel(context.Background())
 
-	go p.handleHeartbeats()
-
-	return p
-}
-
-func (p *Producer) handleHeartbeats() {
 	// Subscribe to heartbeats, and respond when they come in
-	heartbeats := make(chan struct{}, 100)
-	subID, err := p.Bus.Subscribe(p.StreamInfo.HeartbeatTopic.Req(), func(c *hub.Context) {
+	heartbeats := make(chan struct{}, 1)
+	var err error
+	p.subID, err = p.Bus.Subscribe(p.StreamInfo.HeartbeatTopic.Req(), func(c *hub.Context) {
+		hb := heartbeat{}
+		if c.Bind(&hb) == nil {
+			if err := p.heartbeat(hb); err != nil {
+				c.RespondError(err)
+				return
+			}
+		}
 		c.Respond(struct{}{}) // Ack
-		heartbeats <- struct{}{}
+		p.attachOnce.Do(func() {
+			close(p.attached)
+		})
+		select {
+		case heartbeats <- struct{}{}:
+		default:
+		}
 	})
 	if err != nil {
-		panic(fmt.Errorf("Subscribing to heartbeats [%s] failed with error: %s", p.StreamInfo.HeartbeatTopic, err.Error()))
+		p.cancel()
+		return nil, fmt.Errorf("Subscribing to heartbeats [%s] failed with error: %w", p.StreamInfo.HeartbeatTopic, err)
 	}
-	defer func() {
-		p.Bus.Unsubscribe(subID)
-	}()
 
-	// If we do not receive heartbeats in the heartbeat interval
-	// unsubscribe to heartbeats & set `isClosed` to true
+	go p.handleHeartbeats(heartbeats)
+
+	return p, nil
+}
+
+// heartbeat grants the credits of the heartbeat, and sends the events of a
+// replay it asks for or allows to continue.
+func (p *Producer) heartbeat(hb heartbeat) error {
+	if p.StreamInfo.FlowControl {
+		p.grant(hb.Limit)
+	}
+	if hb.Resume != nil {
+		return p.resume(*hb.Resume, hb.Limit)
+	}
+	return p.continueReplay(hb.Limit)
+}
+
+// handleHeartbeats closes the producer when no heartbeat arrives within the
+// heartbeat interval.
+func (p *Producer) handleHeartbeats(heartbeats chan struct{}) {
+	timer := time.NewTimer(p.StreamInfo.HeartbeatInterval)
+	defer timer.Stop()
+
 	for {
 		select {
 		case <-heartbeats:
-			continue
-		case <-time.After(p.StreamInfo.HeartbeatInterval):
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(p.StreamInfo.HeartbeatInterval)
+		case <-timer.C:
 			p.Close()
 			return
-		case <-p.closeHeartbeatsChan:
+		case <-p.ctx.Done():
 			return
 		}
 	}
 }
 
+// Send publishes the event on the stream, stamped with the next sequence
+// number. Concurrent sends are published one at a time, in sequence order.
+//
+// Under flow control, it waits for credits when the consumer has no room
+// for the event, returning ErrClosed if the producer closes meanwhile.
+// Producers of streams served by Handle accept the stream with their first
+// send, unless they did with Accept, then wait for the consumer to attach.
 func (p *Producer) Send(event interface{}) error {
-	return p.Bus.Publish(p.StreamInfo.StreamTopic, event)
+	return p.SendContext(context.Background(), event)
 }
 
-func (p *Producer) IsOpen() bool {
-	return !p.isClosed
+// SendContext behaves like Send, but stops waiting for credits, or for the
+// consumer to attach, once the context is done, returning its error.
+func (p *Producer) SendContext(ctx context.Context, event interface{}) error {
+	p.sendLock.Lock()
+	defer p.sendLock.Unlock()
+
+	if err := p.accept(ctx); err != nil {
+		return err
+	}
+	if err := p.wait(ctx, p.blocked); err != nil {
+		return err
+	}
+	return p.send(event)
 }
 
-func (p *Producer) Close() {
-	if p.isClosed {
-		return
+// blocked reports whether sends must wait, for credits or for a replay to
+// end.
+func (p *Producer) blocked() bool {
+	return (p.StreamInfo.FlowControl && p.Credits() == 0) || p.replaying()
+}
+
+// wait waits until sends are no longer blocked, returning ErrClosed if the
+// producer closes meanwhile.
+func (p *Producer) wait(ctx context.Context, blocked func() bool) error {
+	for blocked() {
+		select {
+		case <-p.granted:
+		case <-p.ctx.Done():
+			return ErrClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// TrySend behaves like Send, but returns ErrBackpressure rather than waiting
+// for credits, or for the consumer to attach.
+func (p *Producer) TrySend(event interface{}) error {
+	p.sendLock.Lock()
+	defer p.sendLock.Unlock()
+
+	if err := p.tryAccept(); err != nil {
+		return err
+	}
+	if p.blocked() {
+		return ErrBackpressure
+	}
+	return p.send(event)
+}
+
+func (p *Producer) send(event interface{}) error {
+	seq := p.sequence + 1
+	if err := p.publish(seq, event); err != nil {
+		return err
+	}
+
+	p.lock.Lock()
+	p.sequence = seq
+	if len(p.replay) > 0 {
+		p.replay[seq%uint64(len(p.replay))] = event
 	}
+	p.lock.Unlock()
+	return nil
+}
 
-	p.isClosed = true
-	p.closeHeartbeatsChan <- struct{}{}
+func (p *Producer) publish(seq uint64, event interface{}, opts ...hub.MessageOption) error {
+	opts = append(opts, hub.WithHeader(HeaderSequence, strconv.FormatUint(seq, 10)))
+	return p.Bus.Publish(p.StreamInfo.StreamTopic, event, opts...)
+}
+
+// End sends the end of the stream after the events sent so far, then closes
+// the producer. The consumer returns io.EOF once it consumed them all.
+func (p *Producer) End() error {
+	p.sendLock.Lock()
+	defer p.sendLock.Unlock()
+
+	// the end takes no room in the buffer of the consumer, it needs no
+	// credits, but comes after any replay
+	if err := p.accept(context.Background()); err != nil {
+		return err
+	}
+	if err := p.wait(context.Background(), p.replaying); err != nil {
+		return err
+	}
+	seq := p.sequence + 1
+	if err := p.publish(seq, struct{}{}, hub.WithHeader(HeaderEnd, "true")); err != nil {
+		return err
+	}
+
+	p.lock.Lock()
+	p.sequence = seq
+	p.lock.Unlock()
+
+	p.Close()
+	return nil
+}
+
+// Sequence returns the sequence number of the last event sent.
+func (p *Producer) Sequence() uint64 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.sequence
+}
+
+// Done returns a channel closed once the producer is closed.
+func (p *Producer) Done() <-chan struct{} {
+	return p.ctx.Done()
+}
+
+func (p *Producer) IsOpen() bool {
+	return p.ctx.Err() == nil
+}
+
+// Close stops the stream, unsubscribing from heartbeats. It may be called
+// more than once.
+func (p *Producer) Close() {
+	p.cancel()
+	p.Bus.Unsubscribe(p.subID)
 }
@@ -16,8 +16,7 @@ func TestProducerHeartbeat(t *testing.T) {
 
 	topic := hub.Topic(uuid.New())
 	producer := streamer.NewProducer(bus, topic)
-
-	<-time.After(time.Millisecond * 50)
+	defer producer.Close()
 
 	// Send n heartbeats, expect them all to
 	// be acknowledged
@@ -56,29 +55,24 @@ func TestProducerSend(t *testing.T) {
 	// Mock consumers
 	for i := 0; i < 3; i++ {
 		wg.Add(1)
-		go func(idx int) {
-			counter := 0
-			mtx := &sync.Mutex{}
-			bus.Listen(producer.StreamInfo.StreamTopic, func(c *hub.Context) {
-				mtx.Lock()
-				defer mtx.Unlock()
-
-				counter++
-				if counter == n {
-					wg.Done()
-				}
-			})
-		}(i)
+		counter := 0
+		mtx := &sync.Mutex{}
+		bus.Listen(producer.StreamInfo.StreamTopic, func(c *hub.Context) {
+			mtx.Lock()
+			defer mtx.Unlock()
+
+			counter++
+			if counter == n {
+				wg.Done()
+			}
+		})
 	}
 
-	// Give consumers time to boot
-	time.Sleep(time.Millisecond * 100)
-
 	// Publish n times
 	for i := 0; i < n; i++ {
 		go func() {
 			if err := producer.Send(struct{}{}); err != nil {
-				t.Fatalf("Producer send interface failed with error: %s", err.Error())
+				t.Errorf("Producer send interface failed with error: %s", err.Error())
 			}
 		}()
 	}
@@ -110,7 +104,10 @@ func TestProducerTimeout(t *testing.T) {
 		si.HeartbeatInterval = dur
 	})
 
-	<-time.After(dur + (time.Millisecond * 10))
+	select {
+	case <-producer.Done():
+	case <-time.After(dur + (time.Millisecond * 100)):
+	}
 
 	if producer.IsOpen() {
 		t.Fatalf("Producer should be closed")
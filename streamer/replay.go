@@ -0,0 +1,116 @@
+package streamer
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/jorgeolivero/hub"
+)
+
+// CodeReplayUnavailable is the code of ErrReplayUnavailable.
+const CodeReplayUnavailable = "REPLAY_UNAVAILABLE"
+
+// ErrReplayUnavailable closes consumers resuming a stream whose producer no
+// longer holds the events they missed. It is a remote error, matching the
+// errors received from producers with errors.Is.
+var ErrReplayUnavailable = hub.NewRemoteError(CodeReplayUnavailable, "Stream replay unavailable")
+
+// resume sends again the events after the sequence number, with their
+// original sequence numbers, as far as the limit granted by the consumer
+// allows. The rest is sent as the consumer grants more, sends waiting
+// meanwhile so that the consumer catches up first.
+func (p *Producer) resume(after, limit uint64) error {
+	p.lock.Lock()
+	oldest := p.oldestReplayable()
+	if after+1 < oldest {
+		p.lock.Unlock()
+		return hub.NewRemoteError(CodeReplayUnavailable, fmt.Sprintf("Stream replay unavailable: events %d to %d no longer held", after+1, oldest-1)).
+			WithDetail("oldest", strconv.FormatUint(oldest, 10))
+	}
+	p.replayNext = after + 1
+	p.replayEnd = p.sequence
+	p.lock.Unlock()
+
+	return p.continueReplay(limit)
+}
+
+// continueReplay sends the events of a pending replay up to the limit
+// granted by the consumer, zero meaning no limit.
+func (p *Producer) continueReplay(limit uint64) error {
+	p.replayLock.Lock()
+	defer p.replayLock.Unlock()
+
+	p.lock.Lock()
+	from, to := p.replayNext, p.replayEnd
+	if limit > 0 && limit < to {
+		to = limit
+	}
+	events := []interface{}{}
+	for seq := from; seq <= to; seq++ {
+		events = append(events, p.replay[seq%uint64(len(p.replay))])
+	}
+	p.lock.Unlock()
+
+	for i, event := range events {
+		seq := from + uint64(i)
+		if err := p.publish(seq, event); err != nil {
+			return err
+		}
+
+		p.lock.Lock()
+		p.replayNext = seq + 1
+		p.lock.Unlock()
+	}
+
+	// wake up the sends waiting for the replay to end
+	if !p.replaying() {
+		select {
+		case p.granted <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// replaying reports whether events of a replay remain to be sent.
+func (p *Producer) replaying() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.replayNext <= p.replayEnd
+}
+
+// oldestReplayable returns the sequence number of the oldest event held in
+// the replay buffer, or the next one when it is empty.
+func (p *Producer) oldestReplayable() uint64 {
+	size := uint64(len(p.replay))
+	if p.sequence < size {
+		return 1
+	}
+	return p.sequence - size + 1
+}
+
+// Offset returns the sequence number of the last event returned by Next, to
+// resume the stream from with WithResume.
+func (c *Consumer) Offset() uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.offset
+}
+
+// consumedSequence records the sequence number of an event returned by
+// Next.
+func (c *Consumer) consumedSequence(cc *hub.Context) {
+	seq, ok := Sequence(cc)
+	if !ok {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if seq > c.offset {
+		c.offset = seq
+	}
+}
@@ -0,0 +1,104 @@
+package streamer_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jorgeolivero/hub"
+	"github.com/jorgeolivero/hub/streamer"
+)
+
+func TestConsumerResume(t *testing.T) {
+	bus := GetBus(t)
+	producer := streamer.NewProducer(bus, hub.Topic("resume"), streamer.WithReplay(10))
+	defer producer.Close()
+
+	consumer := streamer.NewConsumer(bus, producer.StreamInfo)
+	for i := 0; i < 3; i++ {
+		if err := producer.Send(i); err != nil {
+			t.Fatalf("Error sending: %s", err.Error())
+		}
+	}
+	ExpectSequence(t, consumer, 1, 2)
+	consumer.Close()
+	if consumer.Offset() != 2 {
+		t.Fatalf("Expected: 2 Got: %d", consumer.Offset())
+	}
+
+	// events sent while no consumer is attached are replayed
+	for i := 3; i < 5; i++ {
+		if err := producer.Send(i); err != nil {
+			t.Fatalf("Error sending: %s", err.Error())
+		}
+	}
+
+	resumed := streamer.NewConsumer(bus, producer.StreamInfo, streamer.WithResume(consumer.Offset()))
+	defer resumed.Close()
+	ExpectSequence(t, resumed, 3, 4, 5)
+
+	if err := producer.Send(5); err != nil {
+		t.Fatalf("Error sending: %s", err.Error())
+	}
+	ExpectSequence(t, resumed, 6)
+}
+
+func TestConsumerResumeUnavailable(t *testing.T) {
+	bus := GetBus(t)
+	producer := streamer.NewProducer(bus, hub.Topic("resume"), streamer.WithReplay(2))
+	defer producer.Close()
+
+	for i := 0; i < 5; i++ {
+		if err := producer.Send(i); err != nil {
+			t.Fatalf("Error sending: %s", err.Error())
+		}
+	}
+
+	consumer := streamer.NewConsumer(bus, producer.StreamInfo, streamer.WithResume(1))
+	select {
+	case <-consumer.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("Consumer should be closed")
+	}
+	if !errors.Is(consumer.Err(), streamer.ErrReplayUnavailable) {
+		t.Fatalf("Expected replay unavailable, got: %v", consumer.Err())
+	}
+}
+
+func TestConsumerResumeBacklog(t *testing.T) {
+	bus := GetBus(t)
+	producer := streamer.NewProducer(bus, hub.Topic("resume"), streamer.WithReplay(100), streamer.WithBufferSize(10))
+	defer producer.Close()
+
+	for i := 0; i < 50; i++ {
+		if err := producer.Send(i); err != nil {
+			t.Fatalf("Error sending: %s", err.Error())
+		}
+	}
+
+	// the backlog is replayed as the consumer makes room for it
+	gaps := make(chan streamer.Gap, 1)
+	consumer := streamer.NewConsumer(bus, producer.StreamInfo, streamer.WithResume(0), streamer.WithGapHandler(func(gap streamer.Gap) {
+		gaps <- gap
+	}))
+	defer consumer.Close()
+	time.Sleep(time.Millisecond * 50)
+
+	seqs := []uint64{}
+	for seq := uint64(1); seq <= 50; seq++ {
+		seqs = append(seqs, seq)
+	}
+	ExpectSequence(t, consumer, seqs...)
+
+	// sends resume once the replay is over
+	if err := producer.Send(50); err != nil {
+		t.Fatalf("Error sending: %s", err.Error())
+	}
+	ExpectSequence(t, consumer, 51)
+
+	select {
+	case gap := <-gaps:
+		t.Fatalf("Unexpected gap: %#v", gap)
+	default:
+	}
+}
@@ -0,0 +1,167 @@
+package streamer
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/jorgeolivero/hub"
+)
+
+// HeaderSequence holds the sequence number of a stream event, starting at 1
+// and increasing by one with each event sent.
+const HeaderSequence = "Stream-Sequence"
+
+// HeaderEnd marks the end of a stream, sent by Producer.End after the last
+// event.
+const HeaderEnd = "Stream-End"
+
+// ErrGap closes consumers in strict ordering mode that miss events.
+var ErrGap = errors.New("Stream gap")
+
+// maxGaps bounds the number of gaps remembered to recognize late events.
+const maxGaps = 64
+
+// Gap is a range of events that did not arrive in time, From and To
+// included.
+type Gap struct {
+	From, To uint64
+}
+
+func (g Gap) contains(seq uint64) bool {
+	return g.From <= seq && seq <= g.To
+}
+
+// Sequence returns the sequence number of the event, false when the event
+// was not sent by a Producer.
+func Sequence(c *hub.Context) (uint64, bool) {
+	seq, err := strconv.ParseUint(c.Header(HeaderSequence), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return seq, true
+}
+
+func isEnd(c *hub.Context) bool {
+	return len(c.Header(HeaderEnd)) > 0
+}
+
+// reorderer puts events back in sequence order, holding up to window events
+// arrived ahead of a missing one. Once the window is full, the missing
+// events are given up on and reported as a gap.
+type reorderer struct {
+	opts    *ConsumerOptions
+	next    uint64
+	pending map[uint64]*hub.Context
+	gaps    []Gap
+}
+
+func newReorderer(opts *ConsumerOptions, first uint64) *reorderer {
+	return &reorderer{
+		opts:    opts,
+		next:    first,
+		pending: make(map[uint64]*hub.Context),
+	}
+}
+
+// add returns the events ready to be consumed, in order, once the event
+// arrived. It returns an error wrapping ErrGap when events are missing in
+// strict mode, along with the events ready before them.
+func (r *reorderer) add(seq uint64, c *hub.Context) ([]*hub.Context, error) {
+	if seq < r.next {
+		// events given up on are delivered late, unless ordering is strict
+		if i := r.gapIndex(seq); i >= 0 && !r.opts.Strict {
+			r.removeFromGap(i, seq)
+			return []*hub.Context{c}, nil
+		}
+		r.duplicate(seq)
+		return nil, nil
+	}
+	if _, ok := r.pending[seq]; ok {
+		r.duplicate(seq)
+		return nil, nil
+	}
+	r.pending[seq] = c
+
+	ready := r.drain(nil)
+	// give up on the missing events once the window is full, the next one
+	// is missing as pending ones were drained
+	for len(r.pending) > r.window() {
+		gap := Gap{From: r.next, To: r.lowestPending() - 1}
+		if r.opts.Strict {
+			return ready, fmt.Errorf("%w: events %d to %d missing", ErrGap, gap.From, gap.To)
+		}
+		r.skip(gap)
+		ready = r.drain(ready)
+	}
+	return ready, nil
+}
+
+// drain appends the pending events following in sequence to ready.
+func (r *reorderer) drain(ready []*hub.Context) []*hub.Context {
+	for {
+		c, ok := r.pending[r.next]
+		if !ok {
+			return ready
+		}
+		delete(r.pending, r.next)
+		ready = append(ready, c)
+		r.next++
+	}
+}
+
+func (r *reorderer) window() int {
+	if r.opts.ReorderWindow <= 0 {
+		return 0
+	}
+	return r.opts.ReorderWindow
+}
+
+func (r *reorderer) lowestPending() uint64 {
+	lowest := uint64(0)
+	for seq := range r.pending {
+		if lowest == 0 || seq < lowest {
+			lowest = seq
+		}
+	}
+	return lowest
+}
+
+func (r *reorderer) skip(gap Gap) {
+	r.next = gap.To + 1
+	r.gaps = append(r.gaps, gap)
+	if len(r.gaps) > maxGaps {
+		r.gaps = r.gaps[1:]
+	}
+	if r.opts.OnGap != nil {
+		r.opts.OnGap(gap)
+	}
+}
+
+func (r *reorderer) gapIndex(seq uint64) int {
+	for i, gap := range r.gaps {
+		if gap.contains(seq) {
+			return i
+		}
+	}
+	return -1
+}
+
+// removeFromGap splits the gap around the late event.
+func (r *reorderer) removeFromGap(i int, seq uint64) {
+	gap := r.gaps[i]
+	gaps := append([]Gap{}, r.gaps[:i]...)
+	if gap.From < seq {
+		gaps = append(gaps, Gap{From: gap.From, To: seq - 1})
+	}
+	if seq < gap.To {
+		gaps = append(gaps, Gap{From: seq + 1, To: gap.To})
+	}
+	r.gaps = append(gaps, r.gaps[i+1:]...)
+}
+
+func (r *reorderer) duplicate(seq uint64) {
+	if r.opts.OnDuplicate != nil {
+		r.opts.OnDuplicate(seq)
+	}
+}
@@ -0,0 +1,157 @@
+package streamer_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jorgeolivero/hub"
+	"github.com/jorgeolivero/hub/streamer"
+)
+
+// PublishSequence publishes events as a producer would, their sequence
+// number as payload.
+func PublishSequence(t *testing.T, bus *hub.Bus, si streamer.StreamInfo, seqs ...uint64) {
+	for _, seq := range seqs {
+		if err := bus.Publish(si.StreamTopic, seq, hub.WithHeader(streamer.HeaderSequence, fmt.Sprint(seq))); err != nil {
+			t.Fatalf("Error publishing: %s", err.Error())
+		}
+	}
+}
+
+// ExpectSequence consumes events, expecting the sequence numbers.
+func ExpectSequence(t *testing.T, consumer *streamer.Consumer, seqs ...uint64) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	for _, expected := range seqs {
+		c, err := consumer.Next(ctx)
+		if err != nil {
+			t.Fatalf("Error receiving event %d: %s", expected, err.Error())
+		}
+		seq, ok := streamer.Sequence(c)
+		if !ok || seq != expected {
+			t.Fatalf("Expected: %d Got: %d", expected, seq)
+		}
+	}
+}
+
+func TestConsumerReorder(t *testing.T) {
+	bus := GetBus(t)
+	si := GenerateStreamInfo()
+	consumer := streamer.NewConsumer(bus, si)
+	defer consumer.Close()
+
+	PublishSequence(t, bus, si, 2, 3, 1, 4)
+	ExpectSequence(t, consumer, 1, 2, 3, 4)
+}
+
+func TestConsumerGapsAndDuplicates(t *testing.T) {
+	bus := GetBus(t)
+	si := GenerateStreamInfo()
+
+	gaps := make(chan streamer.Gap, 1)
+	duplicates := make(chan uint64, 1)
+	consumer := streamer.NewConsumer(bus, si,
+		streamer.WithReorderWindow(2),
+		streamer.WithGapHandler(func(gap streamer.Gap) {
+			gaps <- gap
+		}),
+		streamer.WithDuplicateHandler(func(seq uint64) {
+			duplicates <- seq
+		}))
+	defer consumer.Close()
+
+	// 2 is given up on once 3 events wait for it
+	PublishSequence(t, bus, si, 1, 3, 4, 5)
+	ExpectSequence(t, consumer, 1, 3, 4, 5)
+	select {
+	case gap := <-gaps:
+		if gap != (streamer.Gap{From: 2, To: 2}) {
+			t.Fatalf("Unexpected gap: %#v", gap)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Gap not reported")
+	}
+
+	// late events are still delivered, duplicates are not
+	PublishSequence(t, bus, si, 2, 4, 6)
+	ExpectSequence(t, consumer, 2, 6)
+	select {
+	case seq := <-duplicates:
+		if seq != 4 {
+			t.Fatalf("Expected: 4 Got: %d", seq)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Duplicate not reported")
+	}
+}
+
+func TestConsumerStrictOrdering(t *testing.T) {
+	bus := GetBus(t)
+	si := GenerateStreamInfo()
+	consumer := streamer.NewConsumer(bus, si, streamer.WithReorderWindow(1), streamer.WithStrictOrdering())
+
+	PublishSequence(t, bus, si, 1, 3, 4)
+	ExpectSequence(t, consumer, 1)
+
+	if _, err := consumer.Next(context.Background()); err != io.EOF {
+		t.Fatalf("Expected end of stream, got: %v", err)
+	}
+	if !errors.Is(consumer.Err(), streamer.ErrGap) {
+		t.Fatalf("Expected gap error, got: %v", consumer.Err())
+	}
+}
+
+func TestConsumerStrictOrderingFullWindow(t *testing.T) {
+	bus := GetBus(t)
+	si := GenerateStreamInfo()
+	consumer := streamer.NewConsumer(bus, si, streamer.WithReorderWindow(2), streamer.WithStrictOrdering())
+	defer consumer.Close()
+
+	// the missing event arrives once the window is full
+	PublishSequence(t, bus, si, 2, 3, 1, 4)
+	ExpectSequence(t, consumer, 1, 2, 3, 4)
+	if err := consumer.Err(); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+}
+
+func TestConsumerStrictOrderingNoWindow(t *testing.T) {
+	bus := GetBus(t)
+	si := GenerateStreamInfo()
+	consumer := streamer.NewConsumer(bus, si, streamer.WithReorderWindow(0), streamer.WithStrictOrdering())
+
+	PublishSequence(t, bus, si, 1, 2, 4)
+	ExpectSequence(t, consumer, 1, 2)
+
+	if _, err := consumer.Next(context.Background()); err != io.EOF {
+		t.Fatalf("Expected end of stream, got: %v", err)
+	}
+	if err := consumer.Err(); !errors.Is(err, streamer.ErrGap) || !strings.Contains(err.Error(), "events 3 to 3") {
+		t.Fatalf("Expected gap error, got: %v", err)
+	}
+}
+
+func TestProducerSequence(t *testing.T) {
+	bus := GetBus(t)
+	topic := hub.Topic("sequence")
+	producer := streamer.NewProducer(bus, topic)
+	defer producer.Close()
+	consumer := streamer.NewConsumer(bus, producer.StreamInfo)
+	defer consumer.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := producer.Send(i); err != nil {
+			t.Fatalf("Error sending: %s", err.Error())
+		}
+	}
+	if producer.Sequence() != 3 {
+		t.Fatalf("Expected: 3 Got: %d", producer.Sequence())
+	}
+	ExpectSequence(t, consumer, 1, 2, 3)
+}
@@ -1,7 +1,7 @@
 package streamer_test
 
 import (
-	"fmt"
+	"context"
 	"testing"
 	"time"
 
@@ -24,19 +24,24 @@ func TestStream(t *testing.T) {
 		for i := 0; i < n; i++ {
 			err := producer.Send(i)
 			if err != nil {
-				t.Fatalf("Send event to stream failed with error: %s", err.Error())
+				t.Errorf("Send event to stream failed with error: %s", err.Error())
 			}
 		}
 	}()
 
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
 	for i := 0; i < n; i++ {
-		c := consumer.Next()
+		c, err := consumer.Next(ctx)
+		if err != nil {
+			t.Fatalf("Receiving event from stream failed with error: %s", err.Error())
+		}
 		var count int
 		if err := c.Bind(&count); err != nil {
 			t.Fatalf("Binding stream count failed with error: %s", err.Error())
 		}
 		if i != count {
-			fmt.Printf("Expected: %d Got: %d\n", i, count)
+			t.Fatalf("Expected: %d Got: %d", i, count)
 		}
 	}
 }
@@ -52,7 +57,10 @@ func TestStreamConsumerClose(t *testing.T) {
 	consumer := streamer.NewConsumer(bus, producer.StreamInfo)
 	consumer.Close()
 
-	<-time.After(timeout + (time.Millisecond * 5))
+	select {
+	case <-producer.Done():
+	case <-time.After(timeout + (time.Millisecond * 100)):
+	}
 
 	if producer.IsOpen() {
 		t.Fatalf("Producer should have timed out")
@@ -71,7 +79,10 @@ func TestStreamProducerClose(t *testing.T) {
 
 	producer.Close()
 
-	<-time.After(bus.DefaultTimeout + producer.StreamInfo.HeartbeatInterval)
+	select {
+	case <-consumer.Done():
+	case <-time.After(bus.DefaultTimeout + producer.StreamInfo.HeartbeatInterval):
+	}
 	if consumer.IsOpen() {
 		t.Fatalf("Expect consumer to be closed")
 	}
@@ -6,10 +6,27 @@ import (
 	"github.com/jorgeolivero/hub"
 )
 
+// DefaultBufferSize is the number of events a consumer holds when the
+// stream does not set one.
+const DefaultBufferSize = 50
+
 type StreamInfo struct {
 	StreamTopic       hub.Topic     `json:"topic"`
 	HeartbeatTopic    hub.Topic     `json:"heartbeatTopic"`
 	HeartbeatInterval time.Duration `json:"heartbeatInterval"`
+
+	// BufferSize is the number of events the consumer holds until they
+	// are consumed, DefaultBufferSize when zero.
+	BufferSize int `json:"bufferSize,omitempty"`
+
+	// FlowControl keeps the producer from sending more events than the
+	// consumer has room for. The consumer grants credits with its
+	// heartbeats, and the producer waits for them once it runs out.
+	FlowControl bool `json:"flowControl,omitempty"`
+
+	// ReplaySize is the number of events the producer keeps once sent, so
+	// that a consumer can resume the stream after dropping, see WithResume.
+	ReplaySize int `json:"replaySize,omitempty"`
 }
 
 func NewStreamInfo(topic hub.Topic, opts ...func(si *StreamInfo)) StreamInfo {
@@ -25,3 +42,44 @@ func NewStreamInfo(topic hub.Topic, opts ...func(si *StreamInfo)) StreamInfo {
 
 	return si
 }
+
+// WithBufferSize sets the number of events the consumer of the stream
+// holds.
+func WithBufferSize(n int) func(si *StreamInfo) {
+	return func(si *StreamInfo) {
+		si.BufferSize = n
+	}
+}
+
+// WithFlowControl keeps the producer of the stream from sending more events
+// than the consumer has room for.
+func WithFlowControl() func(si *StreamInfo) {
+	return func(si *StreamInfo) {
+		si.FlowControl = true
+	}
+}
+
+// WithReplay keeps the last n events sent on the stream, for consumers
+// resuming it.
+func WithReplay(n int) func(si *StreamInfo) {
+	return func(si *StreamInfo) {
+		si.ReplaySize = n
+	}
+}
+
+func (si StreamInfo) bufferSize() int {
+	if si.BufferSize <= 0 {
+		return DefaultBufferSize
+	}
+	return si.BufferSize
+}
+
+// heartbeat is sent by consumers to keep the producer alive. Under flow
+// control it grants credits, the producer may send events up to the Limit
+// sequence number. The first heartbeat of a resuming consumer asks for the
+// events after the Resume sequence number to be sent again, as far as the
+// Limit, the next ones allowing the replay to continue.
+type heartbeat struct {
+	Limit  uint64  `json:"limit,omitempty"`
+	Resume *uint64 `json:"resume,omitempty"`
+}
@@ -4,3 +4,119 @@ type Subscription struct {
 	ID       string
 	Messages chan *Message
 }
+
+// SubscriptionOptions configures how a subscription made through the bus
+// dispatches messages to its handler.
+type SubscriptionOptions struct {
+	// Middleware wraps the handler of the subscription, inside of the
+	// middleware registered on the bus.
+	Middleware []Middleware
+
+	// MaxInFlight limits the number of handlers running concurrently,
+	// zero means unlimited.
+	MaxInFlight int
+
+	// Ordered runs handlers one at a time, in the order messages arrive.
+	Ordered bool
+
+	// QueueSize is the number of messages held while MaxInFlight handlers
+	// are running, Overflow decides what happens once it is full.
+	QueueSize int
+	Overflow  OverflowPolicy
+
+	// AckPolicy decides when messages are acknowledged, for providers that
+	// expect it.
+	AckPolicy AckPolicy
+
+	// Retry runs the handler again when it fails, messages still failing
+	// are published to the DeadLetter topic when set.
+	Retry      RetryPolicy
+	DeadLetter Topic
+}
+
+// OverflowPolicy decides what happens to a message arriving while the queue
+// of a bounded subscription is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock stops reading from the subscription until there is
+	// room, pushing back on the provider.
+	OverflowBlock OverflowPolicy = iota
+
+	// OverflowDropOldest discards the oldest queued message, or the
+	// arriving one when the queue size is zero.
+	OverflowDropOldest
+
+	// OverflowDropNewest discards the arriving message.
+	OverflowDropNewest
+
+	// OverflowReject discards the arriving message, replying to it with an
+	// error wrapping ErrOverloaded when possible.
+	OverflowReject
+)
+
+type SubscriptionOption func(o *SubscriptionOptions)
+
+// WithMiddleware adds middleware to a single subscription.
+func WithMiddleware(middleware ...Middleware) SubscriptionOption {
+	return func(o *SubscriptionOptions) {
+		o.Middleware = append(o.Middleware, middleware...)
+	}
+}
+
+// WithMaxInFlight limits the number of handlers of a subscription running
+// concurrently.
+func WithMaxInFlight(n int) SubscriptionOption {
+	return func(o *SubscriptionOptions) {
+		o.MaxInFlight = n
+	}
+}
+
+// WithOrderedDelivery runs the handlers of a subscription one at a time, in
+// the order messages arrive.
+func WithOrderedDelivery() SubscriptionOption {
+	return func(o *SubscriptionOptions) {
+		o.Ordered = true
+	}
+}
+
+// WithQueue bounds the number of messages a limited subscription holds
+// while its handlers are busy.
+func WithQueue(size int, overflow OverflowPolicy) SubscriptionOption {
+	return func(o *SubscriptionOptions) {
+		o.QueueSize = size
+		o.Overflow = overflow
+	}
+}
+
+// WithAckPolicy sets when the messages of a subscription are acknowledged,
+// after the handler returns by default.
+func WithAckPolicy(policy AckPolicy) SubscriptionOption {
+	return func(o *SubscriptionOptions) {
+		o.AckPolicy = policy
+	}
+}
+
+// WithRetry runs the handler of a subscription again when it panics or
+// responds with an error, according to the policy.
+func WithRetry(policy RetryPolicy) SubscriptionOption {
+	return func(o *SubscriptionOptions) {
+		o.Retry = policy
+	}
+}
+
+// WithDeadLetter publishes the messages whose handler failed every attempt
+// to the topic, see ReplayDeadLetters.
+func WithDeadLetter(topic Topic) SubscriptionOption {
+	return func(o *SubscriptionOptions) {
+		o.DeadLetter = topic
+	}
+}
+
+func newSubscriptionOptions(opts ...SubscriptionOption) *SubscriptionOptions {
+	o := &SubscriptionOptions{}
+	for _, f := range opts {
+		f(o)
+	}
+	return o
+}
@@ -0,0 +1,189 @@
+package hub_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jorgeolivero/hub"
+	"github.com/pborman/uuid"
+)
+
+func TestMaxInFlight(t *testing.T) {
+	bc := GetBusConnection(t)
+	bus := hub.NewBus(bc, hub.JSON)
+
+	topic := hub.Topic(uuid.New())
+	max := 2
+	n := 10
+	lock := &sync.Mutex{}
+	running, peak := 0, 0
+	wg := &sync.WaitGroup{}
+	wg.Add(n)
+
+	// subscribe
+	subID, err := bus.Subscribe(topic, func(c *hub.Context) {
+		defer wg.Done()
+
+		lock.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		lock.Unlock()
+
+		time.Sleep(time.Millisecond * 5)
+
+		lock.Lock()
+		running--
+		lock.Unlock()
+	}, hub.WithMaxInFlight(max))
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+	defer func(subID string) {
+		bus.Unsubscribe(subID)
+	}(subID)
+
+	// publish
+	for i := 0; i < n; i++ {
+		if err := bus.Publish(topic, Envelope{}); err != nil {
+			t.Fatalf("Error publishing to bus: %s", err.Error())
+		}
+	}
+
+	wg.Wait()
+	if peak > max {
+		t.Fatalf("%d handlers ran concurrently, expected at most %d", peak, max)
+	}
+}
+
+func TestOrderedDelivery(t *testing.T) {
+	bc := GetBusConnection(t)
+	bus := hub.NewBus(bc, hub.JSON)
+
+	topic := hub.Topic(uuid.New())
+	n := 20
+	received := make(chan int, n)
+
+	// subscribe
+	subID, err := bus.Subscribe(topic, func(c *hub.Context) {
+		var i int
+		if err := c.Bind(&i); err != nil {
+			t.Errorf("error binding request: %s", err.Error())
+		}
+		received <- i
+	}, hub.WithOrderedDelivery())
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+	defer func(subID string) {
+		bus.Unsubscribe(subID)
+	}(subID)
+
+	// publish
+	for i := 0; i < n; i++ {
+		if err := bus.Publish(topic, i); err != nil {
+			t.Fatalf("Error publishing to bus: %s", err.Error())
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		select {
+		case got := <-received:
+			if got != i {
+				t.Fatalf("Expected: %d Got: %d", i, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out")
+		}
+	}
+}
+
+func TestQueueOverflowReject(t *testing.T) {
+	bc := GetBusConnection(t)
+	bus := hub.NewBus(bc, hub.JSON)
+
+	topic := hub.Topic(uuid.New())
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+
+	// subscribe, the first request keeps the only handler busy
+	subID, err := bus.Subscribe(topic.Req(), func(c *hub.Context) {
+		started <- struct{}{}
+		<-release
+		c.Respond(Envelope{})
+	}, hub.WithMaxInFlight(1), hub.WithQueue(0, hub.OverflowReject))
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+	defer func(subID string) {
+		bus.Unsubscribe(subID)
+	}(subID)
+
+	// publish
+	done := make(chan error, 1)
+	go func() {
+		done <- bus.Request(topic, Envelope{}, &Envelope{})
+	}()
+	<-started
+
+	err = bus.Request(topic, Envelope{}, &Envelope{})
+	if !errors.Is(err, hub.ErrOverloaded) {
+		t.Fatalf("Expected overloaded error, got: %v", err)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("Error requesting to bus: %s", err.Error())
+	}
+}
+
+func TestQueueOverflowDropNewest(t *testing.T) {
+	bc := GetBusConnection(t)
+	bus := hub.NewBus(bc, hub.JSON)
+
+	topic := hub.Topic(uuid.New())
+	release := make(chan struct{})
+	received := make(chan int, 10)
+
+	// subscribe, the handler is blocked until all messages are published
+	subID, err := bus.Subscribe(topic, func(c *hub.Context) {
+		<-release
+		var i int
+		c.Bind(&i)
+		received <- i
+	}, hub.WithOrderedDelivery(), hub.WithQueue(1, hub.OverflowDropNewest))
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+	defer func(subID string) {
+		bus.Unsubscribe(subID)
+	}(subID)
+
+	// publish, one message is handled, one queued, the rest dropped
+	for i := 0; i < 5; i++ {
+		if err := bus.Publish(topic, i); err != nil {
+			t.Fatalf("Error publishing to bus: %s", err.Error())
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+	close(release)
+
+	for _, expected := range []int{0, 1} {
+		select {
+		case got := <-received:
+			if got != expected {
+				t.Fatalf("Expected: %d Got: %d", expected, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out")
+		}
+	}
+	select {
+	case got := <-received:
+		t.Fatalf("Message %d should have been dropped", got)
+	case <-time.After(time.Millisecond * 50):
+	}
+}
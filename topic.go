@@ -57,3 +57,25 @@ func (t Topic) IsModified() {
 		panic(fmt.Errorf("Unable to transform topic > %s", t.String()))
 	}
 }
+
+// Matches reports whether the subject is matched by the topic, read as a
+// pattern following the NATs wildcard rules: `*` matches a single token and
+// `>` matches one or more trailing tokens. Providers routing messages
+// themselves match subjects with it.
+func (t Topic) Matches(subject string) bool {
+	patternTokens := strings.Split(t.String(), ".")
+	subjectTokens := strings.Split(subject, ".")
+
+	for i, token := range patternTokens {
+		if token == ">" {
+			return len(subjectTokens) > i
+		}
+		if i >= len(subjectTokens) {
+			return false
+		}
+		if token != "*" && token != subjectTokens[i] {
+			return false
+		}
+	}
+	return len(patternTokens) == len(subjectTokens)
+}
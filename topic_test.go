@@ -0,0 +1,30 @@
+package hub_test
+
+import (
+	"testing"
+
+	"github.com/jorgeolivero/hub"
+)
+
+func TestTopicMatches(t *testing.T) {
+	cases := []struct {
+		topic   hub.Topic
+		subject string
+		matches bool
+	}{
+		{"a.b", "a.b", true},
+		{"a.b", "a.c", false},
+		{"a.*", "a.b", true},
+		{"a.*", "a.b.c", false},
+		{"a.>", "a.b.c", true},
+		{"a.>", "a", false},
+		{"a.*.c", "a.b.c", true},
+		{"a.b.c", "a.b", false},
+	}
+
+	for _, c := range cases {
+		if c.topic.Matches(c.subject) != c.matches {
+			t.Errorf("Topic [%s] matching [%s] Expected: %t", c.topic, c.subject, c.matches)
+		}
+	}
+}
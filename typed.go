@@ -0,0 +1,60 @@
+package hub
+
+import (
+	"context"
+)
+
+// Call requests the topic and returns the typed response, see
+// Bus.Request.
+func Call[Req, Res any](bus *Bus, topic Topic, req Req, opts ...MessageOption) (Res, error) {
+	return CallContext[Req, Res](context.Background(), bus, topic, req, opts...)
+}
+
+// CallContext requests the topic and returns the typed response, see
+// Bus.RequestContext.
+func CallContext[Req, Res any](ctx context.Context, bus *Bus, topic Topic, req Req, opts ...MessageOption) (Res, error) {
+	var res Res
+	if err := bus.RequestContext(ctx, topic, req, &res, opts...); err != nil {
+		var zero Res
+		return zero, err
+	}
+	return res, nil
+}
+
+// Handle subscribes the handler to the requests of the topic, those made
+// with Call or Bus.Request. The returned response or error is sent back as
+// with Reply and Typed.
+func Handle[Req, Res any](bus *Bus, topic Topic, handler func(*Context, Req) (Res, error), opts ...SubscriptionOption) (string, error) {
+	return bus.Subscribe(topic.Req(), Reply(Typed(handler)), opts...)
+}
+
+// Publish publishes the typed message to the topic, see Bus.Publish.
+func Publish[T any](bus *Bus, topic Topic, msg T, opts ...MessageOption) error {
+	return bus.Publish(topic, msg, opts...)
+}
+
+// Subscribe invokes the handler with the typed messages of the topic, nodes
+// of the same service sharing them, see Bus.Subscribe. Messages failing to
+// bind are not handled.
+func Subscribe[T any](bus *Bus, topic Topic, handler func(*Context, T), opts ...SubscriptionOption) (string, error) {
+	return bus.Subscribe(topic, bind(handler), opts...)
+}
+
+// Listen invokes the handler with the typed messages of the topic, every
+// node receiving each of them, see Bus.Listen. Messages failing to bind are
+// not handled.
+func Listen[T any](bus *Bus, topic Topic, handler func(*Context, T), opts ...SubscriptionOption) (string, error) {
+	return bus.Listen(topic, bind(handler), opts...)
+}
+
+// bind adapts a handler of typed messages into a MessageHandler.
+func bind[T any](handler func(*Context, T)) MessageHandler {
+	return func(c *Context) {
+		var msg T
+		if err := c.Bind(&msg); err != nil {
+			c.fail(err)
+			return
+		}
+		handler(c, msg)
+	}
+}
@@ -0,0 +1,103 @@
+package hub_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jorgeolivero/hub"
+	"github.com/pborman/uuid"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestCallHandle(t *testing.T) {
+	bc := GetBusConnection(t)
+	bus := hub.NewBus(bc, hub.JSON)
+
+	topic := hub.Topic(uuid.New())
+
+	// subscribe
+	subID, err := hub.Handle(bus, topic, func(c *hub.Context, req Envelope) (Envelope, error) {
+		if len(req.Foo) == 0 {
+			return Envelope{}, errors.New("missing foo")
+		}
+		return Envelope{Foo: req.Foo, Bar: "bar"}, nil
+	})
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+	defer bus.Unsubscribe(subID)
+
+	// call
+	res, err := hub.Call[Envelope, Envelope](bus, topic, Envelope{Foo: "foo"})
+	if err != nil {
+		t.Fatalf("Error calling: %s", err.Error())
+	}
+	if res.Foo != "foo" || res.Bar != "bar" {
+		t.Fatalf("Unexpected response: %#v", res)
+	}
+
+	res, err = hub.Call[Envelope, Envelope](bus, topic, Envelope{})
+	if err == nil || err.Error() != "missing foo" {
+		t.Fatalf("Expected missing foo error, got: %v", err)
+	}
+	if res != (Envelope{}) {
+		t.Fatalf("Expected a zero response along with the error, got: %#v", res)
+	}
+}
+
+func TestCallHandleProtobuf(t *testing.T) {
+	bc := GetBusConnection(t)
+	bus := hub.NewBus(bc, hub.BINARY)
+
+	topic := hub.Topic(uuid.New())
+
+	// subscribe, protobuf messages are used through pointers
+	subID, err := hub.Handle(bus, topic, func(c *hub.Context, req *wrapperspb.StringValue) (*wrapperspb.StringValue, error) {
+		return wrapperspb.String(req.GetValue() + " world"), nil
+	})
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+	defer bus.Unsubscribe(subID)
+
+	// call
+	res, err := hub.Call[*wrapperspb.StringValue, *wrapperspb.StringValue](bus, topic, wrapperspb.String("hello"))
+	if err != nil {
+		t.Fatalf("Error calling: %s", err.Error())
+	}
+	if res.GetValue() != "hello world" {
+		t.Fatalf("Unexpected response: %q", res.GetValue())
+	}
+}
+
+func TestPublishListen(t *testing.T) {
+	bc := GetBusConnection(t)
+	bus := hub.NewBus(bc, hub.JSON)
+
+	topic := hub.Topic(uuid.New())
+	received := make(chan Envelope, 1)
+
+	// listen
+	subID, err := hub.Listen(bus, topic, func(c *hub.Context, msg Envelope) {
+		received <- msg
+	})
+	if err != nil {
+		t.Fatalf("Error listening: %s", err.Error())
+	}
+	defer bus.Unsubscribe(subID)
+
+	// publish
+	if err := hub.Publish(bus, topic, Envelope{Foo: "foo"}); err != nil {
+		t.Fatalf("Error publishing: %s", err.Error())
+	}
+
+	select {
+	case msg := <-received:
+		if msg.Foo != "foo" {
+			t.Fatalf("Expected: foo Got: %s", msg.Foo)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out")
+	}
+}
@@ -0,0 +1,60 @@
+// Package wire defines how hub messages are encoded on the network, so that
+// services written in other languages can speak hub. Providers that ship
+// bytes, such as the NATs provider, use it to encode every message.
+//
+// Two encodings of the same envelope exist, JSON and BINARY. Both start with
+// a version, currently 1, and decoders reject versions they do not know.
+// A decoder tells them apart by their first byte: 0x48 ('H') for BINARY,
+// and '{' for JSON once leading whitespace is skipped.
+//
+// # JSON
+//
+// A JSON object with the following members, empty ones are omitted. Decoders
+// accept any valid JSON holding them, in any order.
+//
+//	v            number   envelope version, always present
+//	id           string   unique message id
+//	topic        string   subject the message is published to
+//	reply        string   subject responses are expected on
+//	isResponse   bool     true for responses
+//	contentType  string   content type of data, e.g. "application/json"
+//	headers      object   string values keyed by header name
+//	error        object   {code, message, details, service}, on error responses
+//	data         string   payload bytes, base64 encoded (RFC 4648, padded)
+//
+// Marshal produces a canonical form: the members in the order above, those
+// of error in the order listed, headers and details keys sorted in ascending
+// byte order, no whitespace between tokens, and strings escaped as Go's
+// encoding/json does. That is, '"', '\\' and control characters are escaped,
+// '<', '>' and '&' as \u003c, \u003e and \u0026, U+2028 and U+2029 as \u2028
+// and \u2029, and other characters are written as UTF-8.
+//
+// # BINARY
+//
+// A sequence of fields, where a string or bytes field is its length as an
+// unsigned varint (as in protobuf) followed by that many bytes, and a map is
+// its number of entries as an unsigned varint followed by alternating key
+// and value strings, keys sorted in ascending byte order:
+//
+//	magic        1 byte   0x48
+//	version      1 byte   0x01
+//	flags        1 byte   bit 0 isResponse, bit 1 error present
+//	id           string
+//	topic        string
+//	reply        string
+//	contentType  string
+//	headers      map
+//	data         bytes
+//	error        only when flag bit 1 is set:
+//	  code       string
+//	  message    string
+//	  service    string
+//	  details    map
+//
+// The file testdata/vectors.json holds conformance vectors: each names a
+// message and gives both of its encodings, JSON as a string and BINARY in
+// hex. Implementations must decode both to the same message, and encode
+// that message back to exactly the same BINARY bytes. Their JSON encoding
+// need only decode to the same message, the vectors hold the canonical form
+// for those producing it.
+package wire
@@ -0,0 +1,257 @@
+package wire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/jorgeolivero/hub"
+)
+
+// Format is an encoding of the envelope.
+type Format string
+
+const (
+	JSON   Format = "json"
+	BINARY Format = "binary"
+)
+
+// Version of the envelope produced by Marshal.
+const Version = 1
+
+const (
+	binaryMagic = 0x48
+
+	flagIsResponse = 1 << 0
+	flagError      = 1 << 1
+)
+
+var (
+	ErrUnknownFormat      = errors.New("Unknown wire format")
+	ErrUnsupportedVersion = errors.New("Unsupported envelope version")
+	ErrMalformed          = errors.New("Malformed envelope")
+)
+
+type envelope struct {
+	Version     int               `json:"v"`
+	ID          string            `json:"id,omitempty"`
+	Topic       string            `json:"topic,omitempty"`
+	Reply       string            `json:"reply,omitempty"`
+	IsResponse  bool              `json:"isResponse,omitempty"`
+	ContentType string            `json:"contentType,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Error       *hub.RemoteError  `json:"error,omitempty"`
+	Data        []byte            `json:"data,omitempty"`
+}
+
+// Marshal encodes the message in the provided format.
+func Marshal(msg *hub.Message, format Format) ([]byte, error) {
+	switch format {
+	case JSON:
+		return marshalJSON(msg)
+	case BINARY:
+		return marshalBinary(msg), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, string(format))
+	}
+}
+
+// Unmarshal decodes a message encoded in either format. JSON may be
+// preceded by whitespace.
+func Unmarshal(data []byte) (*hub.Message, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w: empty", ErrMalformed)
+	}
+	if data[0] == binaryMagic {
+		return unmarshalBinary(data)
+	}
+
+	trimmed := bytes.TrimLeft(data, " \t\r\n")
+	if len(trimmed) == 0 {
+		return nil, fmt.Errorf("%w: blank", ErrMalformed)
+	}
+	if trimmed[0] != '{' {
+		return nil, fmt.Errorf("%w: leading byte 0x%02x", ErrUnknownFormat, trimmed[0])
+	}
+	return unmarshalJSON(data)
+}
+
+func marshalJSON(msg *hub.Message) ([]byte, error) {
+	return json.Marshal(&envelope{
+		Version:     Version,
+		ID:          msg.ID,
+		Topic:       msg.Topic,
+		Reply:       msg.Reply,
+		IsResponse:  msg.IsResponse,
+		ContentType: msg.ContentType,
+		Headers:     msg.Headers,
+		Error:       msg.Payload.Error,
+		Data:        msg.Payload.Data,
+	})
+}
+
+func unmarshalJSON(data []byte) (*hub.Message, error) {
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformed, err)
+	}
+	if env.Version != Version {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, env.Version)
+	}
+
+	msg := &hub.Message{
+		ID:          env.ID,
+		Topic:       env.Topic,
+		Reply:       env.Reply,
+		IsResponse:  env.IsResponse,
+		ContentType: env.ContentType,
+		Payload: hub.Payload{
+			Error: env.Error,
+		},
+	}
+	if len(env.Headers) > 0 {
+		msg.Headers = env.Headers
+	}
+	if len(env.Data) > 0 {
+		msg.Payload.Data = env.Data
+	}
+	if msg.Payload.Error != nil && len(msg.Payload.Error.Details) == 0 {
+		msg.Payload.Error.Details = nil
+	}
+	return msg, nil
+}
+
+func marshalBinary(msg *hub.Message) []byte {
+	var flags byte
+	if msg.IsResponse {
+		flags |= flagIsResponse
+	}
+	if msg.Payload.Error != nil {
+		flags |= flagError
+	}
+
+	buf := &bytes.Buffer{}
+	buf.Write([]byte{binaryMagic, Version, flags})
+	writeBytes(buf, []byte(msg.ID))
+	writeBytes(buf, []byte(msg.Topic))
+	writeBytes(buf, []byte(msg.Reply))
+	writeBytes(buf, []byte(msg.ContentType))
+	writeMap(buf, msg.Headers)
+	writeBytes(buf, msg.Payload.Data)
+	if e := msg.Payload.Error; e != nil {
+		writeBytes(buf, []byte(e.Code))
+		writeBytes(buf, []byte(e.Message))
+		writeBytes(buf, []byte(e.Service))
+		writeMap(buf, e.Details)
+	}
+	return buf.Bytes()
+}
+
+func unmarshalBinary(data []byte) (*hub.Message, error) {
+	if len(data) < 3 {
+		return nil, fmt.Errorf("%w: truncated header", ErrMalformed)
+	}
+	if data[1] != Version {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, data[1])
+	}
+	flags := data[2]
+	r := &reader{data: data[3:]}
+
+	msg := &hub.Message{
+		ID:          string(r.bytes()),
+		Topic:       string(r.bytes()),
+		Reply:       string(r.bytes()),
+		IsResponse:  flags&flagIsResponse != 0,
+		ContentType: string(r.bytes()),
+		Headers:     r.stringMap(),
+	}
+	msg.Payload.Data = r.bytes()
+	if flags&flagError != 0 {
+		msg.Payload.Error = &hub.RemoteError{
+			Code:    string(r.bytes()),
+			Message: string(r.bytes()),
+			Service: string(r.bytes()),
+			Details: r.stringMap(),
+		}
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(r.data) > 0 {
+		return nil, fmt.Errorf("%w: %d trailing bytes", ErrMalformed, len(r.data))
+	}
+	return msg, nil
+}
+
+func writeBytes(buf *bytes.Buffer, b []byte) {
+	buf.Write(binary.AppendUvarint(nil, uint64(len(b))))
+	buf.Write(b)
+}
+
+func writeMap(buf *bytes.Buffer, m map[string]string) {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	buf.Write(binary.AppendUvarint(nil, uint64(len(keys))))
+	for _, key := range keys {
+		writeBytes(buf, []byte(key))
+		writeBytes(buf, []byte(m[key]))
+	}
+}
+
+// reader consumes binary fields, remembering the first error so fields can
+// be read without checking each one.
+type reader struct {
+	data []byte
+	err  error
+}
+
+func (r *reader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	n, size := binary.Uvarint(r.data)
+	if size <= 0 {
+		r.err = fmt.Errorf("%w: invalid length", ErrMalformed)
+		return 0
+	}
+	r.data = r.data[size:]
+	return n
+}
+
+func (r *reader) bytes() []byte {
+	n := r.uvarint()
+	if r.err != nil {
+		return nil
+	}
+	if n > uint64(len(r.data)) {
+		r.err = fmt.Errorf("%w: truncated field", ErrMalformed)
+		return nil
+	}
+	if n == 0 {
+		return nil
+	}
+	b := append([]byte(nil), r.data[:n]...)
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *reader) stringMap() map[string]string {
+	n := r.uvarint()
+	if r.err != nil || n == 0 {
+		return nil
+	}
+	m := make(map[string]string)
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		key := string(r.bytes())
+		m[key] = string(r.bytes())
+	}
+	return m
+}
@@ -0,0 +1,129 @@
+package wire_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/jorgeolivero/hub"
+	"github.com/jorgeolivero/hub/wire"
+)
+
+type Vector struct {
+	Name   string `json:"name"`
+	JSON   string `json:"json"`
+	Binary string `json:"binary"`
+}
+
+func LoadVectors(t *testing.T) []Vector {
+	data, err := os.ReadFile("testdata/vectors.json")
+	if err != nil {
+		t.Fatalf("Error reading vectors: %s", err.Error())
+	}
+	var vectors []Vector
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		t.Fatalf("Error parsing vectors: %s", err.Error())
+	}
+	return vectors
+}
+
+// TestVectors ensures both encodings of each vector decode to the same
+// message, and that the message encodes back to the same bytes.
+func TestVectors(t *testing.T) {
+	for _, v := range LoadVectors(t) {
+		binaryData, err := hex.DecodeString(v.Binary)
+		if err != nil {
+			t.Fatalf("[%s] invalid hex: %s", v.Name, err.Error())
+		}
+
+		fromJSON, err := wire.Unmarshal([]byte(v.JSON))
+		if err != nil {
+			t.Fatalf("[%s] error decoding JSON: %s", v.Name, err.Error())
+		}
+		fromBinary, err := wire.Unmarshal(binaryData)
+		if err != nil {
+			t.Fatalf("[%s] error decoding BINARY: %s", v.Name, err.Error())
+		}
+		if !reflect.DeepEqual(fromJSON, fromBinary) {
+			t.Fatalf("[%s] encodings decode differently:\n%#v\n%#v", v.Name, fromJSON, fromBinary)
+		}
+
+		jsonData, err := wire.Marshal(fromJSON, wire.JSON)
+		if err != nil {
+			t.Fatalf("[%s] error encoding JSON: %s", v.Name, err.Error())
+		}
+		if string(jsonData) != v.JSON {
+			t.Fatalf("[%s] JSON encoding differs:\n%s\n%s", v.Name, jsonData, v.JSON)
+		}
+		encoded, err := wire.Marshal(fromJSON, wire.BINARY)
+		if err != nil {
+			t.Fatalf("[%s] error encoding BINARY: %s", v.Name, err.Error())
+		}
+		if !bytes.Equal(encoded, binaryData) {
+			t.Fatalf("[%s] BINARY encoding differs:\n%x\n%s", v.Name, encoded, v.Binary)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	msg := &hub.Message{
+		ID:          "id",
+		Topic:       "topic.REQ",
+		Reply:       "topic.RES.unique",
+		ContentType: hub.ContentTypeBinary,
+		Headers:     map[string]string{"b": "2", "a": "1"},
+		Payload: hub.Payload{
+			Data:  []byte{1, 2, 3},
+			Error: hub.NewRemoteError("CODE", "message").WithDetail("k", "v"),
+		},
+	}
+
+	for _, format := range []wire.Format{wire.JSON, wire.BINARY} {
+		data, err := wire.Marshal(msg, format)
+		if err != nil {
+			t.Fatalf("[%s] error encoding: %s", format, err.Error())
+		}
+		decoded, err := wire.Unmarshal(data)
+		if err != nil {
+			t.Fatalf("[%s] error decoding: %s", format, err.Error())
+		}
+		if !reflect.DeepEqual(decoded, msg) {
+			t.Fatalf("[%s] message did not survive the round trip: %#v", format, decoded)
+		}
+	}
+}
+
+func TestUnmarshalLeadingWhitespace(t *testing.T) {
+	msg, err := wire.Unmarshal([]byte(" \r\n\t{\"v\":1,\"id\":\"x\",\"topic\":\"topic\"}"))
+	if err != nil {
+		t.Fatalf("Error decoding: %s", err.Error())
+	}
+	if msg.ID != "x" || msg.Topic != "topic" {
+		t.Fatalf("Incorrect message: %#v", msg)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	cases := map[string]struct {
+		data     []byte
+		expected error
+	}{
+		"empty":          {[]byte{}, wire.ErrMalformed},
+		"blank":          {[]byte(" \n"), wire.ErrMalformed},
+		"unknown format": {[]byte("<xml/>"), wire.ErrUnknownFormat},
+		"json version":   {[]byte(`{"v":2,"id":"x"}`), wire.ErrUnsupportedVersion},
+		"binary version": {[]byte{0x48, 0x02, 0x00}, wire.ErrUnsupportedVersion},
+		"truncated":      {[]byte{0x48, 0x01, 0x00, 0x05, 'a'}, wire.ErrMalformed},
+		"trailing":       {[]byte{0x48, 0x01, 0x00, 0, 0, 0, 0, 0, 0, 0xff}, wire.ErrMalformed},
+	}
+
+	for name, c := range cases {
+		if _, err := wire.Unmarshal(c.data); !errors.Is(err, c.expected) {
+			t.Fatalf("[%s] expected %v, got: %v", name, c.expected, err)
+		}
+	}
+}